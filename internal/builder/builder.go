@@ -1,13 +1,25 @@
 package builder
 
 import (
+	"errors"
 	"fmt"
+
+	"github.com/matroidbe/pgbrew/internal/buildlog"
+	"github.com/matroidbe/pgbrew/internal/manifest"
+	"github.com/matroidbe/pgbrew/internal/pgrx"
 )
 
 // InstallOptions contains options for the Install method.
 type InstallOptions struct {
-	PgConfig string // Path to pg_config
-	UseSudo  bool   // Use sudo for installation
+	PgConfig      string             // Path to pg_config
+	UseSudo       bool               // Use sudo for installation
+	DestDir       string             // Stage files under this directory instead of the live tree
+	MakeArgs      []string           // Extra make arguments (e.g. "USE_PGXS=1")
+	CargoFeatures []string           // Extra cargo features for pgrx builds
+	Env           []string           // Extra environment variables (KEY=VAL)
+	CC            string             // C compiler override
+	Manifest      *manifest.Manifest // Declared by the extension (nil if none)
+	Log           *buildlog.Log      // Captures build output (nil streams it)
 }
 
 // Builder interface defines operations for building PostgreSQL extensions.
@@ -27,8 +39,31 @@ type Builder interface {
 	// Install builds and installs the extension
 	Install(dir string, opts InstallOptions) error
 
-	// NeedsSharedPreload checks if the extension requires shared_preload_libraries
+	// Toolchain describes the compiler toolchain used to build the project
+	Toolchain(dir string, opts InstallOptions) string
+
+	// Compiler returns the C compiler the build uses ("" if left to the build system)
+	Compiler(opts InstallOptions) string
+
+	// NeedsSharedPreload inspects the source for signs that the extension
+	// requires shared_preload_libraries, for when no built module is available
 	NeedsSharedPreload(dir string) bool
+
+	// Test runs the project's own test suite. Returns ErrNoTests if it has none.
+	Test(dir string, opts InstallOptions) error
+
+	// TestsNeedServer reports whether Test runs against the installed extension
+	// on a server reachable through the libpq variables in opts.Env, rather than
+	// building and managing its own test cluster
+	TestsNeedServer() bool
+}
+
+// ErrNoTests is returned by Test when the project has no test suite.
+var ErrNoTests = errors.New("no tests found")
+
+// pgConfigFor returns the pg_config path to build against.
+func pgConfigFor(opts InstallOptions) string {
+	return pgrx.PgConfigPath(opts.PgConfig)
 }
 
 // registeredBuilders holds all available builders in priority order
@@ -49,6 +84,20 @@ func DetectBuilder(dir string) (Builder, error) {
 	return nil, fmt.Errorf("unknown project type: no compatible build system found in %s", dir)
 }
 
+// DetectBuilderFor returns the builder named by the extension's manifest,
+// or else detects one.
+func DetectBuilderFor(dir string, m *manifest.Manifest) (Builder, error) {
+	if m == nil || m.BuildSystem == "" {
+		return DetectBuilder(dir)
+	}
+	for _, b := range registeredBuilders {
+		if b.Name() == m.BuildSystem {
+			return b, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown build system %q in %s", m.BuildSystem, manifest.FileName)
+}
+
 // ListBuilders returns the names of all registered builders
 func ListBuilders() []string {
 	names := make([]string, len(registeredBuilders))
@@ -0,0 +1,217 @@
+package builder
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Compiler describes the C compiler chosen for a build.
+type Compiler struct {
+	CC         string // Command passed to make as CC (may include flags)
+	Configured string // Compiler recorded by pg_config --cc
+	Version    string // First line of the compiler's --version output
+}
+
+// Fallback reports whether a compiler other than pg_config's is used.
+func (c *Compiler) Fallback() bool {
+	return c.CC != c.Configured
+}
+
+// DetectCompiler chooses the C compiler for building against pg_config.
+// An explicit override always wins. Otherwise the compiler PostgreSQL was
+// built with (pg_config --cc) is used if it exists on this system, and only
+// if it doesn't, the first available compatible compiler is used instead.
+// This handles pg_config naming e.g. gcc-12 on a system with only gcc.
+func DetectCompiler(pgConfig string, override string) (*Compiler, error) {
+	configured := strings.TrimSpace(commandOutput(pgConfig, "--cc"))
+	c := &Compiler{Configured: configured}
+
+	if override != "" {
+		c.CC = override
+		c.Version = compilerVersion(override)
+		return c, nil
+	}
+
+	if configured != "" {
+		if fields := strings.Fields(configured); len(fields) > 0 {
+			if _, err := exec.LookPath(fields[0]); err == nil {
+				c.CC = configured
+				c.Version = compilerVersion(configured)
+				return c, nil
+			}
+		}
+	}
+
+	for _, candidate := range compatibleCompilers(configured) {
+		if _, err := exec.LookPath(candidate); err == nil {
+			c.CC = candidate
+			c.Version = compilerVersion(candidate)
+			return c, nil
+		}
+	}
+
+	if configured == "" {
+		return nil, fmt.Errorf("no C compiler found (tried gcc, cc, clang)")
+	}
+	return nil, fmt.Errorf("C compiler %q from pg_config not found, and no compatible compiler is installed", configured)
+}
+
+// compatibleCompilers returns fallback compilers for the one PostgreSQL was
+// built with, preferring the same compiler family.
+func compatibleCompilers(configured string) []string {
+	name := ""
+	if fields := strings.Fields(configured); len(fields) > 0 {
+		name = filepath.Base(fields[0])
+	}
+	if strings.HasPrefix(name, "clang") {
+		return []string{"clang", "cc", "gcc"}
+	}
+	return []string{"gcc", "cc", "clang"}
+}
+
+// compilerVersion returns the first line of a compiler's --version output.
+func compilerVersion(cc string) string {
+	fields := strings.Fields(cc)
+	if len(fields) == 0 {
+		return ""
+	}
+	version := commandOutput(fields[0], "--version")
+	if idx := strings.Index(version, "\n"); idx > 0 {
+		version = version[:idx]
+	}
+	return strings.TrimSpace(version)
+}
+
+// LLVM describes the JIT bitcode toolchain needed by a PostgreSQL built
+// with --with-llvm. PGXS then compiles every C file to bitcode with clang
+// and indexes it with llvm-lto during make install.
+type LLVM struct {
+	Enabled bool     // PostgreSQL was configured with --with-llvm
+	Clang   string   // clang command from Makefile.global
+	LLVMLTO string   // Path to llvm-lto
+	Missing []string // Required tools that were not found
+}
+
+// DetectLLVM checks whether PostgreSQL was built with LLVM and whether the
+// tools PGXS needs for bitcode installation are available.
+func DetectLLVM(pgConfig string) *LLVM {
+	l := &LLVM{}
+	if !strings.Contains(commandOutput(pgConfig, "--configure"), "--with-llvm") {
+		return l
+	}
+	l.Enabled = true
+
+	// Makefile.global records the clang and LLVM paths used at configure time
+	vars := map[string]string{}
+	pgxs := strings.TrimSpace(commandOutput(pgConfig, "--pgxs"))
+	if pgxs != "" {
+		global := filepath.Join(filepath.Dir(filepath.Dir(pgxs)), "Makefile.global")
+		vars = readMakeVars(global, "CLANG", "LLVM_BINPATH")
+	}
+
+	l.Clang = vars["CLANG"]
+	if l.Clang == "" {
+		l.Clang = "clang"
+	}
+	if fields := strings.Fields(l.Clang); len(fields) == 0 {
+		l.Missing = append(l.Missing, "clang")
+	} else if _, err := exec.LookPath(fields[0]); err != nil {
+		l.Missing = append(l.Missing, fields[0])
+	}
+
+	l.LLVMLTO = "llvm-lto"
+	if binPath := vars["LLVM_BINPATH"]; binPath != "" {
+		l.LLVMLTO = filepath.Join(binPath, "llvm-lto")
+	}
+	if _, err := exec.LookPath(l.LLVMLTO); err != nil {
+		l.Missing = append(l.Missing, l.LLVMLTO)
+	}
+
+	return l
+}
+
+// MissingBitcode reports whether an installed set of files lacks the JIT
+// bitcode PGXS emits for its modules when PostgreSQL was built with LLVM.
+func (l *LLVM) MissingBitcode(files []string) bool {
+	if !l.Enabled {
+		return false
+	}
+	hasModule := false
+	for _, f := range files {
+		if strings.Contains(filepath.ToSlash(f), "/bitcode/") {
+			return false
+		}
+		if strings.HasSuffix(f, ".so") || strings.HasSuffix(f, ".dylib") {
+			hasModule = true
+		}
+	}
+	return hasModule
+}
+
+// ModuleSuffix returns the file suffix of loadable modules for the
+// PostgreSQL of pgConfig: DLSUFFIX from its PGXS makefiles, e.g. ".dylib"
+// for PostgreSQL 16 or newer on macOS. It defaults to ".so".
+func ModuleSuffix(pgConfig string) string {
+	pgxs := strings.TrimSpace(commandOutput(pgConfig, "--pgxs"))
+	if pgxs != "" {
+		src := filepath.Dir(filepath.Dir(pgxs))
+		for _, makefile := range []string{"Makefile.global", "Makefile.port"} {
+			if suffix := readMakeVars(filepath.Join(src, makefile), "DLSUFFIX")["DLSUFFIX"]; strings.HasPrefix(suffix, ".") {
+				return suffix
+			}
+		}
+	}
+	return ".so"
+}
+
+// IsModule reports whether path is a loadable module, a file with the
+// module suffix.
+func IsModule(path string, suffix string) bool {
+	return strings.HasSuffix(path, suffix)
+}
+
+// ModuleName strips the directory and the module suffix from a module path
+// or library reference ("$libdir/vector.so" is "vector" for ".so").
+func ModuleName(path string, suffix string) string {
+	return strings.TrimSuffix(filepath.Base(path), suffix)
+}
+
+// readMakeVars extracts simple "NAME = value" assignments from a Makefile.
+func readMakeVars(path string, names ...string) map[string]string {
+	vars := map[string]string{}
+	file, err := os.Open(path)
+	if err != nil {
+		return vars
+	}
+	defer file.Close()
+
+	wanted := map[string]bool{}
+	for _, n := range names {
+		wanted[n] = true
+	}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		name = strings.TrimSpace(strings.TrimSuffix(name, ":"))
+		if wanted[name] {
+			vars[name] = strings.TrimSpace(value)
+		}
+	}
+	return vars
+}
+
+func commandOutput(name string, args ...string) string {
+	output, err := exec.Command(name, args...).Output()
+	if err != nil {
+		return ""
+	}
+	return string(output)
+}
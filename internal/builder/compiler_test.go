@@ -0,0 +1,58 @@
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakePgConfig writes a pg_config script whose PGXS lives under dir, with the
+// given Makefile.global and Makefile.port contents ("" leaves a file out).
+func fakePgConfig(t *testing.T, dir string, global string, port string) string {
+	t.Helper()
+	src := filepath.Join(dir, "lib", "pgxs", "src")
+	if err := os.MkdirAll(filepath.Join(src, "makefiles"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"Makefile.global": global, "Makefile.port": port} {
+		if content == "" {
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(src, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pgConfig := filepath.Join(dir, "pg_config")
+	script := "#!/bin/sh\necho " + filepath.Join(src, "makefiles", "pgxs.mk") + "\n"
+	if err := os.WriteFile(pgConfig, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return pgConfig
+}
+
+func TestModuleSuffix(t *testing.T) {
+	tests := []struct {
+		name   string
+		global string
+		port   string
+		want   string
+	}{
+		{"global", "DLSUFFIX = .dylib\n", "", ".dylib"},
+		{"port", "CC = cc\n", "DLSUFFIX = .dylib\n", ".dylib"},
+		{"global before port", "DLSUFFIX = .so\n", "DLSUFFIX = .dylib\n", ".so"},
+		{"not set", "CC = cc\n", "", ".so"},
+		{"not a suffix", "DLSUFFIX = $(SO_SUFFIX)\n", "", ".so"},
+		{"no makefiles", "", "", ".so"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pgConfig := fakePgConfig(t, t.TempDir(), tt.global, tt.port)
+			if got := ModuleSuffix(pgConfig); got != tt.want {
+				t.Errorf("ModuleSuffix = %q, want %q", got, tt.want)
+			}
+		})
+	}
+	if got := ModuleSuffix(filepath.Join(t.TempDir(), "missing")); got != ".so" {
+		t.Errorf("ModuleSuffix without pg_config = %q, want .so", got)
+	}
+}
@@ -28,10 +28,47 @@ func (b *PgrxBuilder) GetVersion(dir string) (string, error) {
 }
 
 func (b *PgrxBuilder) Install(dir string, opts InstallOptions) error {
-	return pgrx.Install(dir, pgrx.InstallOptions{
-		PgConfig: opts.PgConfig,
-		UseSudo:  opts.UseSudo,
-	})
+	return pgrx.Install(dir, pgrxOptions(opts))
+}
+
+// Test runs cargo pgrx test, which builds the extension and runs it in a
+// cluster that pgrx creates and tears down itself.
+func (b *PgrxBuilder) Test(dir string, opts InstallOptions) error {
+	return pgrx.Test(dir, pgrxOptions(opts))
+}
+
+func (b *PgrxBuilder) TestsNeedServer() bool {
+	return false
+}
+
+// pgrxOptions converts builder options to pgrx options. Features and the
+// build method declared in the manifest are applied here.
+func pgrxOptions(opts InstallOptions) pgrx.InstallOptions {
+	po := pgrx.InstallOptions{
+		PgConfig:      opts.PgConfig,
+		UseSudo:       opts.UseSudo,
+		DestDir:       opts.DestDir,
+		MakeArgs:      opts.MakeArgs,
+		CargoFeatures: opts.CargoFeatures,
+		Env:           opts.Env,
+		CC:            opts.CC,
+		Log:           opts.Log,
+	}
+	if m := opts.Manifest; m != nil {
+		po.CargoFeatures = append(append([]string{}, m.Features...), opts.CargoFeatures...)
+		po.UseMakefile = m.UseMakefile
+	}
+	return po
+}
+
+// Compiler returns the C compiler used for build scripts, if overridden;
+// otherwise cargo's cc crate picks one.
+func (b *PgrxBuilder) Compiler(opts InstallOptions) string {
+	return opts.CC
+}
+
+func (b *PgrxBuilder) Toolchain(dir string, opts InstallOptions) string {
+	return pgrx.Toolchain(dir)
 }
 
 func (b *PgrxBuilder) NeedsSharedPreload(dir string) bool {
@@ -3,6 +3,7 @@ package builder
 import (
 	"bufio"
 	"fmt"
+	"io/fs"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -98,82 +99,167 @@ func parseControlVersion(controlPath string) (string, error) {
 
 // Install builds and installs the extension using make.
 func (b *PgxsBuilder) Install(dir string, opts InstallOptions) error {
-	// Determine pg_config path
-	pgConfig := opts.PgConfig
-	if pgConfig == "" {
-		pgConfig = os.Getenv("PG_CONFIG")
-	}
-	if pgConfig == "" {
-		pgConfig = "pg_config"
-	}
+	pgConfig := pgConfigFor(opts)
 
 	// Build make arguments
-	// Override CC to use the system's default gcc, since PostgreSQL's pg_config
-	// may reference a specific version (e.g., gcc-12) that isn't installed
 	makeArgs := []string{
 		"PG_CONFIG=" + pgConfig,
-		"CC=gcc",
 	}
 
+	// Only override CC when pg_config's compiler is unusable (e.g. it names
+	// gcc-12 but only gcc is installed) or the user asked for another one,
+	// so PGXS keeps the compiler its CFLAGS were written for
+	cc, err := DetectCompiler(pgConfig, opts.CC)
+	if err != nil {
+		return err
+	}
+	if cc.Fallback() {
+		fmt.Printf("Using C compiler: %s (pg_config has %s)\n", cc.CC, cc.Configured)
+		makeArgs = append(makeArgs, "CC="+cc.CC)
+	} else {
+		fmt.Printf("Using C compiler: %s\n", cc.CC)
+	}
+
+	// With --with-llvm, make install also emits JIT bitcode, which needs
+	// clang and llvm-lto. Skip the bitcode rather than fail when they're missing.
+	if llvm := DetectLLVM(pgConfig); llvm.Enabled && len(llvm.Missing) > 0 {
+		fmt.Printf("⚠ PostgreSQL was built with LLVM, but %s not found; skipping JIT bitcode\n", strings.Join(llvm.Missing, " and "))
+		makeArgs = append(makeArgs, "with_llvm=no")
+	}
+
+	makeArgs = append(makeArgs, opts.MakeArgs...)
+	env := append(os.Environ(), opts.Env...)
+
 	// Run make clean (ignore errors - may not have been built before)
 	cleanArgs := append([]string{"clean"}, makeArgs...)
 	cleanCmd := exec.Command("make", cleanArgs...)
 	cleanCmd.Dir = dir
+	cleanCmd.Env = env
+	cleanCmd.Stdout = opts.Log.Writer()
+	cleanCmd.Stderr = opts.Log.Writer()
 	cleanCmd.Run() // Ignore errors
 
 	// Run make
-	fmt.Println("Running make...")
 	makeCmd := exec.Command("make", makeArgs...)
 	makeCmd.Dir = dir
-	makeCmd.Stdout = os.Stdout
-	makeCmd.Stderr = os.Stderr
-	if err := makeCmd.Run(); err != nil {
+	makeCmd.Env = env
+	if err := opts.Log.Run("Running make", makeCmd); err != nil {
 		return fmt.Errorf("make failed: %w", err)
 	}
 
-	// Run make install (with sudo if requested)
-	fmt.Println("Running make install...")
+	// Run make install (with sudo if requested). When staging, DESTDIR
+	// redirects the install into a directory we own, so sudo is not needed.
 	installArgs := append([]string{"install"}, makeArgs...)
+	if opts.DestDir != "" {
+		installArgs = append(installArgs, "DESTDIR="+opts.DestDir)
+	}
 	var installCmd *exec.Cmd
-	if opts.UseSudo {
+	if opts.UseSudo && opts.DestDir == "" {
 		// Preserve PATH (for uv), HOME, CARGO_HOME, RUSTUP_HOME (for rustup/cargo)
-		sudoArgs := append([]string{"--preserve-env=PATH,HOME,CARGO_HOME,RUSTUP_HOME", "make"}, installArgs...)
+		// Extra variables are passed through env(1), since sudo resets the environment
+		sudoArgs := []string{"--preserve-env=PATH,HOME,CARGO_HOME,RUSTUP_HOME"}
+		if len(opts.Env) > 0 {
+			sudoArgs = append(append(sudoArgs, "env"), opts.Env...)
+		}
+		sudoArgs = append(append(sudoArgs, "make"), installArgs...)
 		installCmd = exec.Command("sudo", sudoArgs...)
 	} else {
 		installCmd = exec.Command("make", installArgs...)
+		installCmd.Env = env
 	}
 	installCmd.Dir = dir
-	installCmd.Stdout = os.Stdout
-	installCmd.Stderr = os.Stderr
-	if err := installCmd.Run(); err != nil {
+	if err := opts.Log.Run("Running make install", installCmd); err != nil {
 		return fmt.Errorf("make install failed: %w", err)
 	}
 
 	return nil
 }
 
-// NeedsSharedPreload checks if the extension requires shared_preload_libraries.
-// For C extensions, we check the .control file for mentions of shared_preload_libraries.
+// Toolchain returns the version of the C compiler used for the build.
+func (b *PgxsBuilder) Toolchain(dir string, opts InstallOptions) string {
+	cc, err := DetectCompiler(pgConfigFor(opts), opts.CC)
+	if err != nil {
+		return "cc unknown"
+	}
+	if cc.Version == "" {
+		return cc.CC
+	}
+	return cc.Version
+}
+
+// Compiler returns the C compiler the build uses.
+func (b *PgxsBuilder) Compiler(opts InstallOptions) string {
+	cc, err := DetectCompiler(pgConfigFor(opts), opts.CC)
+	if err != nil {
+		return ""
+	}
+	return cc.CC
+}
+
+// NeedsSharedPreload checks the C sources for calls and hooks that only work
+// from shared_preload_libraries (see preloadSymbols).
 func (b *PgxsBuilder) NeedsSharedPreload(dir string) bool {
-	// Check .control files
-	controlFiles, _ := filepath.Glob(filepath.Join(dir, "*.control"))
-	for _, controlFile := range controlFiles {
-		data, err := os.ReadFile(controlFile)
+	found := false
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if d.IsDir() || filepath.Ext(path) != ".c" {
+			return nil
+		}
+		data, err := os.ReadFile(path)
 		if err != nil {
-			continue
+			return nil
 		}
-		content := strings.ToLower(string(data))
-		if strings.Contains(content, "shared_preload") {
-			return true
+		for _, ident := range cIdentifier.FindAllString(string(data), -1) {
+			if preloadSymbols[ident] {
+				found = true
+				return filepath.SkipAll
+			}
 		}
+		return nil
+	})
+	return found
+}
+
+// cIdentifier matches C identifiers.
+var cIdentifier = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_]*`)
+
+// regressPattern matches a REGRESS assignment in a Makefile.
+var regressPattern = regexp.MustCompile(`(?m)^\s*REGRESS\s*[:+?]?=`)
+
+// Test runs the REGRESS tests with make installcheck. pg_regress connects to
+// the server given by the libpq variables (PGHOST, PGPORT, PGUSER) in
+// opts.Env, so the extension must already be installed there.
+func (b *PgxsBuilder) Test(dir string, opts InstallOptions) error {
+	data, err := os.ReadFile(filepath.Join(dir, "Makefile"))
+	if err != nil || !regressPattern.Match(data) {
+		return ErrNoTests
 	}
 
-	// Check Makefile for background worker indicators
-	makefilePath := filepath.Join(dir, "Makefile")
-	data, err := os.ReadFile(makefilePath)
-	if err != nil {
-		return false
+	pgConfig := pgConfigFor(opts)
+	makeArgs := []string{"installcheck", "PG_CONFIG=" + pgConfig}
+	if cc, err := DetectCompiler(pgConfig, opts.CC); err == nil && cc.Fallback() {
+		makeArgs = append(makeArgs, "CC="+cc.CC)
+	}
+	makeArgs = append(makeArgs, opts.MakeArgs...)
+
+	cmd := exec.Command("make", makeArgs...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), opts.Env...)
+	if err := opts.Log.Run("Running make installcheck", cmd); err != nil {
+		diffs := filepath.Join(dir, "regression.diffs")
+		if _, statErr := os.Stat(diffs); statErr == nil {
+			return fmt.Errorf("regression tests failed: %w (differences in %s)", err, diffs)
+		}
+		return fmt.Errorf("make installcheck failed: %w", err)
 	}
-	content := strings.ToLower(string(data))
-	return strings.Contains(content, "bgworker") || strings.Contains(content, "background_worker")
+	return nil
+}
+
+func (b *PgxsBuilder) TestsNeedServer() bool {
+	return true
 }
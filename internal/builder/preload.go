@@ -0,0 +1,51 @@
+package builder
+
+import (
+	"github.com/matroidbe/pgbrew/internal/manifest"
+	"github.com/matroidbe/pgbrew/internal/sharedlib"
+)
+
+// preloadSymbols are server functions and hooks that only work when a module
+// is loaded at server start: static background workers and shared memory and
+// LWLock requests. process_shared_preload_libraries_in_progress isn't one of
+// them; modules check it to support preloading, not to require it.
+var preloadSymbols = map[string]bool{
+	"RegisterBackgroundWorker":  true,
+	"RequestAddinShmemSpace":    true,
+	"RequestNamedLWLockTranche": true,
+	"shmem_startup_hook":        true,
+	"shmem_request_hook":        true,
+}
+
+// NeedsSharedPreload reports whether the extension requires
+// shared_preload_libraries. The manifest takes precedence, then the symbols
+// the built modules use; the builder's source inspection is the fallback
+// when no module can be inspected.
+func NeedsSharedPreload(b Builder, dir string, m *manifest.Manifest, modules []string) bool {
+	if m != nil && m.SharedPreload != nil {
+		return *m.SharedPreload
+	}
+	if needs, ok := ModulesNeedSharedPreload(modules); ok {
+		return needs
+	}
+	return b.NeedsSharedPreload(dir)
+}
+
+// ModulesNeedSharedPreload reports whether any of the built modules use
+// functions or hooks that only work from shared_preload_libraries. ok is
+// false if none of them could be inspected (e.g. on macOS).
+func ModulesNeedSharedPreload(modules []string) (needs bool, ok bool) {
+	for _, module := range modules {
+		symbols, err := sharedlib.ImportedSymbols(module)
+		if err != nil || symbols == nil {
+			continue
+		}
+		ok = true
+		for _, s := range symbols {
+			if preloadSymbols[s] {
+				return true, true
+			}
+		}
+	}
+	return false, ok
+}
@@ -0,0 +1,111 @@
+package builder
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// StagedFiles returns the absolute install paths of all files in a staged tree.
+func StagedFiles(stageDir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(stageDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(stageDir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, string(filepath.Separator)+rel)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Deploy copies every file of a staged tree into its place on the live
+// filesystem and returns the installed paths.
+func Deploy(stageDir string, useSudo bool) ([]string, error) {
+	files, err := StagedFiles(stageDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read staged files: %w", err)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("build produced no files to install")
+	}
+
+	for _, dst := range files {
+		src := filepath.Join(stageDir, dst)
+		if err := deployFile(src, dst, useSudo); err != nil {
+			return nil, fmt.Errorf("failed to install %s: %w", dst, err)
+		}
+	}
+
+	return files, nil
+}
+
+// deployFile installs a single file, creating parent directories as needed.
+func deployFile(src, dst string, useSudo bool) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	mode := fmt.Sprintf("%o", info.Mode().Perm())
+
+	if useSudo {
+		cmd := exec.Command("sudo", "install", "-D", "-m", mode, src, dst)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("%s: %s", err, string(output))
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file and rename, so a running server never
+	// maps a partially written shared library
+	tmp := dst + ".pgbrew-tmp"
+	if err := os.WriteFile(tmp, data, info.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// RemoveFiles deletes installed files. Files that are already gone are not
+// an error; every other failure is reported.
+func RemoveFiles(files []string, useSudo bool) error {
+	var errs []error
+	for _, f := range files {
+		if useSudo {
+			if output, err := exec.Command("sudo", "rm", "-f", f).CombinedOutput(); err != nil {
+				errs = append(errs, fmt.Errorf("failed to remove %s: %s: %s", f, err, strings.TrimSpace(string(output))))
+			}
+			continue
+		}
+		if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, fmt.Errorf("failed to remove %s: %w", f, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,278 @@
+package buildlog
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// verbose controls whether step output is streamed to the terminal
+var verbose bool
+
+// SetVerbose sets whether step output is streamed to the terminal
+func SetVerbose(v bool) {
+	verbose = v
+}
+
+// Verbose reports whether step output is streamed to the terminal.
+func Verbose() bool {
+	return verbose
+}
+
+// stepPrefix marks the start of a step in the log file
+const stepPrefix = "==> "
+
+// Log records the output of every step of one install in a file.
+// A nil *Log is valid and streams step output to the terminal instead.
+type Log struct {
+	path string
+	file *os.File
+}
+
+// Dir returns the directory holding build logs.
+// It honours XDG_STATE_HOME, defaulting to ~/.local/state/pgbrew/logs.
+func Dir() (string, error) {
+	state := os.Getenv("XDG_STATE_HOME")
+	if state == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("could not determine home directory: %w", err)
+		}
+		state = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(state, "pgbrew", "logs"), nil
+}
+
+// Start creates a new log file for name (usually the extension name, or a
+// guess at it until the source has been inspected).
+func Start(name string) (*Log, error) {
+	dir, err := Dir()
+	if err != nil {
+		return nil, err
+	}
+	file, err := createLog(filepath.Join(dir, name))
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Log{path: file.Name(), file: file}
+	fmt.Fprintf(file, "pgbrew build log, started %s\n", time.Now().Format(time.RFC3339))
+	fmt.Fprintf(file, "Command: %s\n\n", strings.Join(os.Args, " "))
+	return l, nil
+}
+
+// createLog creates a new, uniquely named log file in dir. Names start with
+// the time to the microsecond, so they sort chronologically, and end with a
+// random suffix, so builds started at the same moment never share a file.
+func createLog(dir string) (*os.File, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return os.CreateTemp(dir, time.Now().Format("20060102-150405.000000")+"-*.log")
+}
+
+// SetName moves the log under the directory for name, once the extension
+// name is known.
+func (l *Log) SetName(name string) error {
+	if l == nil || filepath.Base(filepath.Dir(l.path)) == name {
+		return nil
+	}
+	dir, err := Dir()
+	if err != nil {
+		return err
+	}
+	newDir := filepath.Join(dir, name)
+	newPath := filepath.Join(newDir, filepath.Base(l.path))
+	if err := os.MkdirAll(newDir, 0755); err != nil {
+		return err
+	}
+	// Never rename over another build's log; claim a fresh name if taken
+	if err := os.Link(l.path, newPath); err != nil {
+		placeholder, err := createLog(newDir)
+		if err != nil {
+			return err
+		}
+		placeholder.Close()
+		newPath = placeholder.Name()
+		// The open file handle stays valid across the rename
+		if err := os.Rename(l.path, newPath); err != nil {
+			os.Remove(newPath)
+			return err
+		}
+	} else {
+		os.Remove(l.path)
+	}
+	oldDir := filepath.Dir(l.path)
+	l.path = newPath
+	os.Remove(oldDir) // Only succeeds if empty
+	return nil
+}
+
+// Path returns the location of the log file.
+func (l *Log) Path() string {
+	if l == nil {
+		return ""
+	}
+	return l.path
+}
+
+// Close closes the log file.
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// Writer returns a writer into the log file, for output that should be
+// kept but never shown. Output is discarded for a nil Log.
+func (l *Log) Writer() io.Writer {
+	if l == nil {
+		return io.Discard
+	}
+	return l.file
+}
+
+// Printf writes a message to the log file only.
+func (l *Log) Printf(format string, args ...any) {
+	if l == nil {
+		return
+	}
+	fmt.Fprintf(l.file, format, args...)
+}
+
+// Run executes cmd as a build step. Its output always goes to the log file;
+// the terminal shows a single progress line unless verbose output is on.
+func (l *Log) Run(step string, cmd *exec.Cmd) error {
+	if l == nil {
+		fmt.Printf("%s...\n", step)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		return cmd.Run()
+	}
+
+	fmt.Fprintf(l.file, "%s%s\n$ %s\n", stepPrefix, step, strings.Join(cmd.Args, " "))
+	if cmd.Dir != "" {
+		fmt.Fprintf(l.file, "  (in %s)\n", cmd.Dir)
+	}
+
+	var out io.Writer = l.file
+	if verbose {
+		fmt.Printf("%s...\n", step)
+		out = io.MultiWriter(l.file, os.Stdout)
+	} else {
+		fmt.Printf("%s... ", step)
+	}
+	cmd.Stdout = out
+	cmd.Stderr = out
+
+	start := time.Now()
+	err := cmd.Run()
+	elapsed := time.Since(start).Round(100 * time.Millisecond)
+
+	if err != nil {
+		fmt.Fprintf(l.file, "\n%sstep failed after %s: %v\n\n", stepPrefix, elapsed, err)
+		if !verbose {
+			fmt.Println("failed")
+		}
+		return err
+	}
+
+	fmt.Fprintf(l.file, "\n")
+	if !verbose {
+		fmt.Printf("done (%s)\n", elapsed)
+	}
+	return nil
+}
+
+// errorLine matches lines that typically start a compiler or tool error
+var errorLine = regexp.MustCompile(`(?i)(\berror\b|\bfatal\b|undefined reference|no such file|not found|cannot find)`)
+
+// ErrorSummary returns the most relevant part of the output of the last
+// step: from the first error-looking line near the end of its output, or
+// else the last lines of output.
+func (l *Log) ErrorSummary() string {
+	if l == nil {
+		return ""
+	}
+	return Summarize(l.path)
+}
+
+// Summarize extracts the most relevant error block from a log file.
+func Summarize(path string) string {
+	const (
+		window   = 200 // Lines at the end of the step searched for errors
+		maxBlock = 30  // Lines shown from the first error
+		tailSize = 20  // Lines shown when no error line is found
+	)
+
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	// Keep only the output of the last step
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, stepPrefix) && !strings.HasPrefix(line, stepPrefix+"step failed") {
+			lines = lines[:0]
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	// Drop trailing blank lines and the failure marker
+	for len(lines) > 0 {
+		last := lines[len(lines)-1]
+		if strings.TrimSpace(last) != "" && !strings.HasPrefix(last, stepPrefix) {
+			break
+		}
+		lines = lines[:len(lines)-1]
+	}
+
+	start := len(lines) - window
+	if start < 0 {
+		start = 0
+	}
+	for i := start; i < len(lines); i++ {
+		if errorLine.MatchString(lines[i]) {
+			end := i + maxBlock
+			if end > len(lines) {
+				end = len(lines)
+			}
+			return strings.Join(lines[i:end], "\n")
+		}
+	}
+
+	start = len(lines) - tailSize
+	if start < 0 {
+		start = 0
+	}
+	return strings.Join(lines[start:], "\n")
+}
+
+// List returns the log files for an extension, most recent first.
+func List(name string) ([]string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return nil, err
+	}
+	logs, err := filepath.Glob(filepath.Join(dir, name, "*.log"))
+	if err != nil {
+		return nil, err
+	}
+	// Timestamped names sort chronologically
+	sort.Sort(sort.Reverse(sort.StringSlice(logs)))
+	return logs, nil
+}
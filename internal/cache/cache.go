@@ -0,0 +1,460 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Key identifies a build by everything that influences its output.
+type Key struct {
+	Commit      string // Source commit SHA
+	Subpath     string // Extension directory inside the repository
+	PgVersion   string // PostgreSQL major version
+	BuildSystem string // Builder name ("pgrx" or "pgxs")
+	Toolchain   string // Compiler / cargo-pgrx versions
+	Options     string // User-supplied build options
+	Manifest    string // Build settings from the manifest or formula
+	PkgLibDir   string // pg_config --pkglibdir the tree is staged for
+	ShareDir    string // pg_config --sharedir the tree is staged for
+}
+
+// Hash returns a short, stable identifier for the key.
+func (k Key) Hash() string {
+	h := sha256.New()
+	for _, part := range []string{k.Commit, k.Subpath, k.PgVersion, k.BuildSystem, k.Toolchain, k.Options, k.Manifest, k.PkgLibDir, k.ShareDir} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// Build describes a cached build tree.
+type Build struct {
+	Key         string    `json:"key"`
+	Name        string    `json:"name"`
+	Version     string    `json:"version"`
+	Source      string    `json:"source"`
+	Repo        string    `json:"repo,omitempty"`
+	Ref         string    `json:"ref,omitempty"` // Ref as requested (tag, branch, or commit)
+	Commit      string    `json:"commit"`
+	Subpath     string    `json:"subpath,omitempty"`
+	PgVersion   string    `json:"pg_version"`
+	BuildSystem string    `json:"build_system"`
+	Toolchain   string    `json:"toolchain"`
+	PkgLibDir   string    `json:"pkglibdir,omitempty"`
+	ShareDir    string    `json:"sharedir,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastUsedAt  time.Time `json:"last_used_at"`
+
+	// Dir is the directory holding the build (not serialized)
+	Dir string `json:"-"`
+}
+
+// TreeDir returns the staged install tree of the build.
+func (b *Build) TreeDir() string {
+	return filepath.Join(b.Dir, "tree")
+}
+
+// Fits reports whether the build's tree installs into a PostgreSQL with the
+// given directories. The tree holds absolute paths, so a build staged for
+// another installation of the same major version must not be deployed.
+// Builds from before the directories were recorded are checked by looking
+// for the extension directory in the tree.
+func (b *Build) Fits(pkgLibDir, shareDir string) bool {
+	if b.ShareDir != "" || b.PkgLibDir != "" {
+		return b.PkgLibDir == pkgLibDir && b.ShareDir == shareDir
+	}
+	_, err := os.Stat(filepath.Join(b.TreeDir(), shareDir, "extension"))
+	return err == nil
+}
+
+// cacheDir overrides the cache location (from the cache_dir setting)
+var cacheDir string
+
+// SetDir sets the root of the pgbrew cache ("" for the default).
+func SetDir(dir string) {
+	cacheDir = dir
+}
+
+// Dir returns the root of the pgbrew cache.
+// It honours SetDir, PGBREW_CACHE_DIR and XDG_CACHE_HOME, defaulting to ~/.cache/pgbrew.
+func Dir() (string, error) {
+	if cacheDir != "" {
+		return cacheDir, nil
+	}
+	if dir := os.Getenv("PGBREW_CACHE_DIR"); dir != "" {
+		return dir, nil
+	}
+	if xdg := os.Getenv("XDG_CACHE_HOME"); xdg != "" {
+		return filepath.Join(xdg, "pgbrew"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("could not determine home directory: %w", err)
+	}
+	return filepath.Join(home, ".cache", "pgbrew"), nil
+}
+
+// bottleDir is a read-only directory of prebuilt builds ("bottles"), laid out
+// like the builds cache. It lets air-gapped hosts install builds made elsewhere.
+var bottleDir string
+
+// SetBottleDir sets the directory searched for prebuilt builds.
+func SetBottleDir(dir string) {
+	bottleDir = dir
+}
+
+// BottleDir returns the configured bottle directory, if any.
+func BottleDir() string {
+	return bottleDir
+}
+
+func buildsDir() (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "builds"), nil
+}
+
+// SourceDir returns the cache directory for a checkout of repo at commit.
+func SourceDir(repo, commit string) (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "src", filepath.FromSlash(repo)+"@"+commit), nil
+}
+
+// TouchSource records that the cached checkout dir was used, so it isn't
+// pruned. The time is kept in a file beside the checkout rather than in the
+// directory's modification time, which building inside it also changes.
+func TouchSource(dir string) error {
+	return os.WriteFile(dir+".used", []byte(time.Now().UTC().Format(time.RFC3339)+"\n"), 0644)
+}
+
+// SourceLastUsed returns when the cached checkout dir was last used.
+// Checkouts from before use was recorded fall back to the directory's
+// modification time.
+func SourceLastUsed(dir string) (time.Time, error) {
+	if data, err := os.ReadFile(dir + ".used"); err == nil {
+		if t, err := time.Parse(time.RFC3339, strings.TrimSpace(string(data))); err == nil {
+			return t, nil
+		}
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// RemoveSource deletes a cached checkout.
+func RemoveSource(dir string) error {
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := os.Remove(dir + ".used"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Lookup returns the cached build for key, or nil if there is none.
+// The bottle directory is consulted when the build cache has no match.
+func Lookup(key Key) (*Build, error) {
+	root, err := buildsDir()
+	if err != nil {
+		return nil, err
+	}
+	b, err := readBuild(filepath.Join(root, key.Hash()))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		if bottleDir == "" {
+			return nil, nil
+		}
+		b, err = readBuild(filepath.Join(bottleDir, key.Hash()))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		return b, nil
+	}
+
+	// Record the hit so prune keeps frequently used builds
+	b.LastUsedAt = time.Now()
+	_ = writeMeta(b)
+	return b, nil
+}
+
+// Store copies a staged install tree into the cache under key.
+func Store(key Key, b Build, stageDir string) (*Build, error) {
+	root, err := buildsDir()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+
+	// Assemble in a temporary directory and rename into place so that an
+	// interrupted store never leaves a half-written build behind
+	tmpDir, err := os.MkdirTemp(root, ".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := CopyTree(stageDir, filepath.Join(tmpDir, "tree")); err != nil {
+		return nil, fmt.Errorf("failed to copy build tree: %w", err)
+	}
+
+	now := time.Now()
+	b.Key = key.Hash()
+	b.Commit = key.Commit
+	b.Subpath = key.Subpath
+	b.PgVersion = key.PgVersion
+	b.BuildSystem = key.BuildSystem
+	b.Toolchain = key.Toolchain
+	b.PkgLibDir = key.PkgLibDir
+	b.ShareDir = key.ShareDir
+	b.CreatedAt = now
+	b.LastUsedAt = now
+	b.Dir = tmpDir
+	if err := writeMeta(&b); err != nil {
+		return nil, err
+	}
+
+	finalDir := filepath.Join(root, b.Key)
+	os.RemoveAll(finalDir)
+	if err := os.Rename(tmpDir, finalDir); err != nil {
+		return nil, err
+	}
+	b.Dir = finalDir
+	return &b, nil
+}
+
+// FindBottle returns the newest build of repo/subpath for a PostgreSQL major
+// version, looking in the build cache and the bottle directory. It is used
+// when the source itself is unavailable (offline without a mirror), so it
+// matches on the requested ref rather than the commit. An empty ref matches
+// any build. Builds staged for other PostgreSQL directories are skipped.
+func FindBottle(repo, subpath, ref, pgVersion, pkgLibDir, shareDir string) (*Build, error) {
+	builds, err := List()
+	if err != nil {
+		return nil, err
+	}
+	if bottleDir != "" {
+		bottles, err := listDir(bottleDir)
+		if err != nil {
+			return nil, err
+		}
+		builds = append(builds, bottles...)
+	}
+
+	var best *Build
+	for i, b := range builds {
+		if b.Repo != repo || b.Subpath != subpath || b.PgVersion != pgVersion {
+			continue
+		}
+		if ref != "" && b.Ref != ref && !strings.HasPrefix(b.Commit, ref) {
+			continue
+		}
+		if !b.Fits(pkgLibDir, shareDir) {
+			continue
+		}
+		if best == nil || b.CreatedAt.After(best.CreatedAt) {
+			best = &builds[i]
+		}
+	}
+	return best, nil
+}
+
+// List returns all cached builds, most recently used first.
+func List() ([]Build, error) {
+	root, err := buildsDir()
+	if err != nil {
+		return nil, err
+	}
+	return listDir(root)
+}
+
+// listDir reads all builds in a directory, most recently used first.
+func listDir(root string) ([]Build, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var builds []Build
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		b, err := readBuild(filepath.Join(root, entry.Name()))
+		if err != nil {
+			continue
+		}
+		builds = append(builds, *b)
+	}
+
+	sort.Slice(builds, func(i, j int) bool {
+		return builds[i].LastUsedAt.After(builds[j].LastUsedAt)
+	})
+	return builds, nil
+}
+
+// Remove deletes a cached build.
+func Remove(b Build) error {
+	return os.RemoveAll(b.Dir)
+}
+
+// PruneSources removes cached source checkouts that have not been used since cutoff.
+func PruneSources(cutoff time.Time) ([]string, error) {
+	clones, err := StaleSources(cutoff)
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	for _, clone := range clones {
+		if err := RemoveSource(clone); err != nil {
+			return removed, err
+		}
+		removed = append(removed, clone)
+	}
+	return removed, nil
+}
+
+// StaleSources returns the cached source checkouts that have not been used
+// since cutoff.
+func StaleSources(cutoff time.Time) ([]string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return nil, err
+	}
+
+	// Checkouts live at src/<host>/<owner>/<repo>@<commit>
+	clones, err := filepath.Glob(filepath.Join(dir, "src", "*", "*", "*@*"))
+	if err != nil {
+		return nil, err
+	}
+
+	var stale []string
+	for _, clone := range clones {
+		info, err := os.Stat(clone)
+		if err != nil || !info.IsDir() {
+			continue
+		}
+		if used, err := SourceLastUsed(clone); err != nil || !used.Before(cutoff) {
+			continue
+		}
+		stale = append(stale, clone)
+	}
+	return stale, nil
+}
+
+// TempDirs returns the half-written builds left behind by interrupted stores.
+func TempDirs() ([]string, error) {
+	root, err := buildsDir()
+	if err != nil {
+		return nil, err
+	}
+	return filepath.Glob(filepath.Join(root, ".tmp-*"))
+}
+
+// Clear removes the entire cache.
+func Clear() error {
+	dir, err := Dir()
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+// Size returns the total size in bytes of all files under dir.
+func Size(dir string) int64 {
+	var total int64
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
+}
+
+// CopyTree recursively copies src to dst, preserving file modes and symlinks.
+func CopyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		default:
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			return os.WriteFile(target, data, info.Mode().Perm())
+		}
+	})
+}
+
+func readBuild(dir string) (*Build, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "meta.json"))
+	if err != nil {
+		return nil, err
+	}
+	var b Build
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, err
+	}
+	b.Dir = dir
+	return &b, nil
+}
+
+func writeMeta(b *Build) error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(b.Dir, "meta.json"), data, 0644)
+}
@@ -18,28 +18,71 @@ func SetUseSudo(sudo bool) {
 	useSudo = sudo
 }
 
+// pgConfig is the pg_config of the PostgreSQL whose cellar is used
+var pgConfig = "pg_config"
+
+// SetPgConfig sets the pg_config of the PostgreSQL whose cellar is used.
+func SetPgConfig(path string) {
+	pgConfig = path
+}
+
 // Entry represents an installed extension.
 type Entry struct {
-	Name        string    `json:"name"`
-	Version     string    `json:"version"`
-	Source      string    `json:"source"`
-	PgVersion   string    `json:"pg_version"`
-	BuildSystem string    `json:"build_system,omitempty"` // "pgrx" or "pgxs"
-	InstalledAt time.Time `json:"installed_at"`
+	Name          string        `json:"name"`
+	Version       string        `json:"version"`
+	Source        string        `json:"source"`
+	Formula       string        `json:"formula,omitempty"` // Tap formula it was installed from ("tap/name")
+	PgVersion     string        `json:"pg_version"`
+	BuildSystem   string        `json:"build_system,omitempty"` // "pgrx" or "pgxs"
+	Commit        string        `json:"commit,omitempty"`
+	Toolchain     string        `json:"toolchain,omitempty"`
+	Compiler      string        `json:"compiler,omitempty"`       // C compiler used for the build
+	Options       *BuildOptions `json:"options,omitempty"`        // Replayed on reinstall
+	SharedPreload bool          `json:"shared_preload,omitempty"` // Must be in shared_preload_libraries
+	Files         []string      `json:"files,omitempty"`          // Installed files (manifest)
+	Pinned        string        `json:"pinned,omitempty"`         // Version installs must keep ("" if not pinned)
+	InstalledAt   time.Time     `json:"installed_at"`
+}
+
+// BuildOptions holds user-supplied build settings for an extension.
+type BuildOptions struct {
+	MakeArgs      []string `json:"make_args,omitempty"`
+	CargoFeatures []string `json:"cargo_features,omitempty"`
+	Env           []string `json:"env,omitempty"`
+	CC            string   `json:"cc,omitempty"`
+}
+
+// IsEmpty reports whether no options are set.
+func (o BuildOptions) IsEmpty() bool {
+	return len(o.MakeArgs) == 0 && len(o.CargoFeatures) == 0 && len(o.Env) == 0 && o.CC == ""
+}
+
+// String formats the options as command-line flags.
+func (o BuildOptions) String() string {
+	var parts []string
+	for _, a := range o.MakeArgs {
+		parts = append(parts, "--make-arg "+a)
+	}
+	for _, f := range o.CargoFeatures {
+		parts = append(parts, "--cargo-feature "+f)
+	}
+	for _, e := range o.Env {
+		parts = append(parts, "--env "+e)
+	}
+	if o.CC != "" {
+		parts = append(parts, "--cc "+o.CC)
+	}
+	return strings.Join(parts, " ")
 }
 
 // Cellar manages installed extensions.
 type Cellar struct {
-	Entries []Entry `json:"entries"`
+	Entries []Entry           `json:"entries"`
+	Pins    map[string]string `json:"pins,omitempty"` // Pins of unlinked extensions, by name
 }
 
 func getCellarPath() (string, error) {
 	// Get extension directory from pg_config
-	pgConfig := os.Getenv("PG_CONFIG")
-	if pgConfig == "" {
-		pgConfig = "pg_config"
-	}
-
 	cmd := exec.Command(pgConfig, "--sharedir")
 	output, err := cmd.Output()
 	if err != nil {
@@ -104,7 +147,8 @@ func save(c *Cellar) error {
 	return os.WriteFile(path, data, 0644)
 }
 
-// Add adds or updates an extension entry.
+// Add adds or updates an extension entry. A pin on the existing entry, or
+// kept when the extension was unlinked, is kept.
 func Add(entry Entry) error {
 	c, err := load()
 	if err != nil {
@@ -117,14 +161,21 @@ func Add(entry Entry) error {
 	found := false
 	for i, e := range c.Entries {
 		if e.Name == entry.Name {
+			if entry.Pinned == "" {
+				entry.Pinned = e.Pinned
+			}
 			c.Entries[i] = entry
 			found = true
 			break
 		}
 	}
 	if !found {
+		if entry.Pinned == "" {
+			entry.Pinned = c.Pins[entry.Name]
+		}
 		c.Entries = append(c.Entries, entry)
 	}
+	delete(c.Pins, entry.Name)
 
 	return save(c)
 }
@@ -154,18 +205,76 @@ func Get(name string) (*Entry, error) {
 	return nil, fmt.Errorf("extension not found: %s", name)
 }
 
-// Remove removes an extension from the cellar.
+// Pinned returns the version an extension is pinned to, whether it is
+// linked or not ("" if it isn't pinned).
+func Pinned(name string) (string, error) {
+	c, err := load()
+	if err != nil {
+		return "", err
+	}
+	for _, e := range c.Entries {
+		if e.Name == name {
+			return e.Pinned, nil
+		}
+	}
+	return c.Pins[name], nil
+}
+
+// SetPinned pins an installed extension to a version ("" unpins it). The
+// pin of an unlinked extension can be changed too.
+func SetPinned(name string, version string) error {
+	c, err := load()
+	if err != nil {
+		return err
+	}
+
+	for i, e := range c.Entries {
+		if e.Name == name {
+			c.Entries[i].Pinned = version
+			return save(c)
+		}
+	}
+	if _, ok := c.Pins[name]; ok {
+		if version == "" {
+			delete(c.Pins, name)
+		} else {
+			c.Pins[name] = version
+		}
+		return save(c)
+	}
+
+	return fmt.Errorf("extension not found: %s", name)
+}
+
+// Unlink removes an extension from the cellar like Remove, but keeps its
+// pin, so linking or installing it again still honours the pin.
+func Unlink(name string) error {
+	return remove(name, true)
+}
+
+// Remove removes an extension, and its pin, from the cellar.
 func Remove(name string) error {
+	return remove(name, false)
+}
+
+func remove(name string, keepPin bool) error {
 	c, err := load()
 	if err != nil {
 		return err
 	}
 
-	found := false
+	_, found := c.Pins[name]
+	delete(c.Pins, name)
 	newEntries := make([]Entry, 0, len(c.Entries))
 	for _, e := range c.Entries {
 		if e.Name == name {
 			found = true
+			if keepPin && e.Pinned != "" {
+				if c.Pins == nil {
+					c.Pins = map[string]string{}
+				}
+				c.Pins[name] = e.Pinned
+			}
 			continue
 		}
 		newEntries = append(newEntries, e)
@@ -0,0 +1,128 @@
+package cellar
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Generation is a snapshot of the exact files of an installation, taken
+// before a later install replaced them.
+type Generation struct {
+	Entry
+	SavedAt time.Time `json:"saved_at"`
+
+	// Dir is the directory holding the snapshot (not serialized)
+	Dir string `json:"-"`
+}
+
+// TreeDir returns the snapshot's files, laid out like a staged install tree.
+func (g *Generation) TreeDir() string {
+	return filepath.Join(g.Dir, "tree")
+}
+
+// Snapshot copies the installed files of entry into a new generation and
+// deletes the oldest generations beyond keep. Files that no longer exist are
+// left out.
+func Snapshot(entry Entry, keep int) (*Generation, error) {
+	dir, err := generationsDir(entry.Name)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	g := Generation{Entry: entry, SavedAt: now, Dir: filepath.Join(dir, strconv.FormatInt(now.UnixNano(), 10))}
+	g.Files = nil
+	for _, f := range entry.Files {
+		if err := copyFile(f, filepath.Join(g.TreeDir(), f)); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			os.RemoveAll(g.Dir)
+			return nil, fmt.Errorf("failed to save %s: %w", f, err)
+		}
+		g.Files = append(g.Files, f)
+	}
+
+	data, err := json.MarshalIndent(g, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(g.Dir, "generation.json"), data, 0644); err != nil {
+		os.RemoveAll(g.Dir)
+		return nil, err
+	}
+
+	gens, err := Generations(entry.Name)
+	if err != nil {
+		return &g, nil
+	}
+	for i := keep; i < len(gens); i++ {
+		os.RemoveAll(gens[i].Dir)
+	}
+	return &g, nil
+}
+
+// Generations returns the snapshots of an extension, newest first.
+func Generations(name string) ([]Generation, error) {
+	dir, err := generationsDir(name)
+	if err != nil {
+		return nil, err
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*", "generation.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var gens []Generation
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var g Generation
+		if err := json.Unmarshal(data, &g); err != nil {
+			continue
+		}
+		g.Dir = filepath.Dir(path)
+		gens = append(gens, g)
+	}
+	sort.Slice(gens, func(i, j int) bool { return gens[i].SavedAt.After(gens[j].SavedAt) })
+	return gens, nil
+}
+
+// RemoveGeneration deletes a snapshot.
+func RemoveGeneration(g Generation) error {
+	return os.RemoveAll(g.Dir)
+}
+
+func generationsDir(name string) (string, error) {
+	store, err := StoreDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(store, name, ".generations"), nil
+}
+
+// copyFile copies a file with its permissions, creating parent directories.
+func copyFile(src, dst string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(dst, data, info.Mode().Perm())
+}
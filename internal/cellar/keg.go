@@ -0,0 +1,159 @@
+package cellar
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/matroidbe/pgbrew/internal/cache"
+)
+
+// Keg is an installed version of an extension, kept in the store so that it
+// can be linked again after another version replaced it.
+type Keg struct {
+	Entry
+
+	// Dir is the directory holding the keg (not serialized)
+	Dir string `json:"-"`
+}
+
+// TreeDir returns the staged install tree of the keg.
+func (k *Keg) TreeDir() string {
+	return filepath.Join(k.Dir, "tree")
+}
+
+// StoreDir returns the directory of the kegs installed into the selected
+// PostgreSQL: <data dir>/pgbrew/Cellar/<target>, where target is derived
+// from its share directory.
+func StoreDir() (string, error) {
+	data := os.Getenv("XDG_DATA_HOME")
+	if data == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("could not determine home directory: %w", err)
+		}
+		data = filepath.Join(home, ".local", "share")
+	}
+
+	output, err := exec.Command(pgConfig, "--sharedir").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to get sharedir from pg_config: %w", err)
+	}
+	target := strings.Trim(strings.ReplaceAll(strings.TrimSpace(string(output)), string(filepath.Separator), "-"), "-")
+	return filepath.Join(data, "pgbrew", "Cellar", target), nil
+}
+
+// SaveKeg copies the install tree of an entry into the store, replacing an
+// existing keg of the same version. Pins belong to the linked entry, so the
+// keg doesn't keep one.
+func SaveKeg(entry Entry, treeDir string) (*Keg, error) {
+	store, err := StoreDir()
+	if err != nil {
+		return nil, err
+	}
+	extDir := filepath.Join(store, entry.Name)
+	if err := os.MkdirAll(extDir, 0755); err != nil {
+		return nil, err
+	}
+
+	// Assemble in a temporary directory and rename into place so that an
+	// interrupted install never leaves a half-written keg behind
+	tmpDir, err := os.MkdirTemp(extDir, ".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := cache.CopyTree(treeDir, filepath.Join(tmpDir, "tree")); err != nil {
+		return nil, fmt.Errorf("failed to copy install tree: %w", err)
+	}
+	entry.InstalledAt = time.Now()
+	entry.Pinned = ""
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "keg.json"), data, 0644); err != nil {
+		return nil, err
+	}
+
+	finalDir := filepath.Join(extDir, kegDirName(entry.Version))
+	os.RemoveAll(finalDir)
+	if err := os.Rename(tmpDir, finalDir); err != nil {
+		return nil, err
+	}
+	return &Keg{Entry: entry, Dir: finalDir}, nil
+}
+
+// Kegs returns the stored versions of an extension, oldest install first.
+// An empty name returns the kegs of all extensions.
+func Kegs(name string) ([]Keg, error) {
+	store, err := StoreDir()
+	if err != nil {
+		return nil, err
+	}
+
+	pattern := filepath.Join(store, "*", "*", "keg.json")
+	if name != "" {
+		pattern = filepath.Join(store, name, "*", "keg.json")
+	}
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	var kegs []Keg
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var k Keg
+		if err := json.Unmarshal(data, &k); err != nil {
+			continue
+		}
+		k.Dir = filepath.Dir(path)
+		kegs = append(kegs, k)
+	}
+	sort.Slice(kegs, func(i, j int) bool {
+		if kegs[i].Name != kegs[j].Name {
+			return kegs[i].Name < kegs[j].Name
+		}
+		return kegs[i].InstalledAt.Before(kegs[j].InstalledAt)
+	})
+	return kegs, nil
+}
+
+// GetKeg returns the stored version of an extension.
+func GetKeg(name string, version string) (*Keg, error) {
+	kegs, err := Kegs(name)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range kegs {
+		if k.Version == version {
+			return &k, nil
+		}
+	}
+	return nil, fmt.Errorf("%s %s is not in the store", name, version)
+}
+
+// RemoveKegs deletes every stored version of an extension.
+func RemoveKegs(name string) error {
+	store, err := StoreDir()
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(filepath.Join(store, name))
+}
+
+// kegDirName returns the directory name of a version, keeping path
+// separators out of it.
+func kegDirName(version string) string {
+	return strings.ReplaceAll(version, string(filepath.Separator), "_")
+}
@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/matroidbe/pgbrew/internal/cache"
+	"github.com/matroidbe/pgbrew/internal/github"
+	"github.com/spf13/cobra"
+)
+
+var cachePruneOlderThan time.Duration
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the build cache",
+	Long: `Manage the build cache.
+
+pgx keeps git mirrors of source repositories, checkouts of the commits it
+built, and the built install trees, so that installing the same extension
+again is near-instant. Builds are keyed by source commit, PostgreSQL
+major version, build system and toolchain versions.
+
+The cache lives in ~/.cache/pgbrew (override with PGBREW_CACHE_DIR).`,
+}
+
+var cacheListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List cached builds",
+	Args:  cobra.NoArgs,
+	RunE:  runCacheList,
+}
+
+var cachePruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove cached builds and checkouts that have not been used recently",
+	Args:  cobra.NoArgs,
+	RunE:  runCachePrune,
+}
+
+var cacheClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Remove everything from the cache",
+	Args:  cobra.NoArgs,
+	RunE:  runCacheClear,
+}
+
+func init() {
+	cachePruneCmd.Flags().DurationVar(&cachePruneOlderThan, "older-than", 30*24*time.Hour, "Remove entries not used within this duration")
+
+	cacheCmd.AddCommand(cacheListCmd)
+	cacheCmd.AddCommand(cachePruneCmd)
+	cacheCmd.AddCommand(cacheClearCmd)
+}
+
+func runCacheList(cmd *cobra.Command, args []string) error {
+	builds, err := cache.List()
+	if err != nil {
+		return fmt.Errorf("failed to read cache: %w", err)
+	}
+
+	dir, _ := cache.Dir()
+
+	mirrors, err := github.Mirrors()
+	if err != nil {
+		return fmt.Errorf("failed to read cache: %w", err)
+	}
+	if len(mirrors) > 0 {
+		fmt.Printf("Git mirrors (%s):\n\n", filepath.Join(dir, "git"))
+		for _, m := range mirrors {
+			size := cache.Size(filepath.Join(dir, "git", m))
+			fmt.Printf("  %-50s %s\n", strings.TrimSuffix(m, ".git"), formatSize(size))
+		}
+		fmt.Println()
+	}
+
+	if len(builds) == 0 {
+		fmt.Printf("No cached builds in %s\n", dir)
+		return nil
+	}
+
+	fmt.Printf("Cached builds (%s):\n\n", dir)
+	var total int64
+	for _, b := range builds {
+		size := cache.Size(b.Dir)
+		total += size
+		fmt.Printf("  %s  %s %s (pg%s, %s)\n", b.Key, b.Name, b.Version, b.PgVersion, b.BuildSystem)
+		fmt.Printf("    Source:    %s (commit %s)\n", b.Source, shortSHA(b.Commit))
+		fmt.Printf("    Toolchain: %s\n", b.Toolchain)
+		fmt.Printf("    Size:      %s, last used %s\n", formatSize(size), b.LastUsedAt.Format("2006-01-02 15:04"))
+	}
+
+	fmt.Println()
+	fmt.Printf("Total: %d builds, %s\n", len(builds), formatSize(total))
+	return nil
+}
+
+func runCachePrune(cmd *cobra.Command, args []string) error {
+	cutoff := time.Now().Add(-cachePruneOlderThan)
+
+	builds, err := cache.List()
+	if err != nil {
+		return fmt.Errorf("failed to read cache: %w", err)
+	}
+
+	var removed int
+	var freed int64
+	for _, b := range builds {
+		if b.LastUsedAt.After(cutoff) {
+			continue
+		}
+		size := cache.Size(b.Dir)
+		if err := cache.Remove(b); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", b.Key, err)
+		}
+		fmt.Printf("  - %s %s %s (pg%s)\n", b.Key, b.Name, b.Version, b.PgVersion)
+		removed++
+		freed += size
+	}
+
+	checkouts, err := cache.PruneSources(cutoff)
+	if err != nil {
+		return fmt.Errorf("failed to prune cached sources: %w", err)
+	}
+	for _, c := range checkouts {
+		fmt.Printf("  - %s\n", c)
+	}
+	if err := github.PruneWorktrees(); err != nil {
+		return err
+	}
+
+	fmt.Printf("✓ Removed %d builds (%s) and %d checkouts\n", removed, formatSize(freed), len(checkouts))
+	return nil
+}
+
+func runCacheClear(cmd *cobra.Command, args []string) error {
+	dir, err := cache.Dir()
+	if err != nil {
+		return err
+	}
+	size := cache.Size(dir)
+	if err := cache.Clear(); err != nil {
+		return fmt.Errorf("failed to clear cache: %w", err)
+	}
+	fmt.Printf("✓ Cleared %s (%s)\n", dir, formatSize(size))
+	return nil
+}
+
+// formatSize formats a byte count for display.
+func formatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
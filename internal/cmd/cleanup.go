@@ -0,0 +1,337 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/matroidbe/pgbrew/internal/builder"
+	"github.com/matroidbe/pgbrew/internal/cache"
+	"github.com/matroidbe/pgbrew/internal/cellar"
+	"github.com/matroidbe/pgbrew/internal/control"
+	"github.com/matroidbe/pgbrew/internal/github"
+	"github.com/spf13/cobra"
+)
+
+var (
+	cleanupDryRun    bool
+	cleanupUseSudo   bool
+	cleanupOlderThan time.Duration
+)
+
+// staleTempAge is how old a temporary directory must be before cleanup
+// assumes the install that created it is no longer running.
+const staleTempAge = 24 * time.Hour
+
+// coreModules are libraries PostgreSQL itself installs into pkglibdir
+// without a control file.
+var coreModules = map[string]bool{
+	"auth_delay":          true,
+	"auto_explain":        true,
+	"basebackup_to_shell": true,
+	"basic_archive":       true,
+	"dict_snowball":       true,
+	"libpqwalreceiver":    true,
+	"llvmjit":             true,
+	"passwordcheck":       true,
+	"pgoutput":            true,
+	"sepgsql":             true,
+	"test_decoding":       true,
+}
+
+var cleanupCmd = &cobra.Command{
+	Use:   "cleanup",
+	Short: "Remove files left behind by interrupted or old operations",
+	Long: `Remove what pgx leaves behind over time:
+
+  - pgbrew-* temporary directories of interrupted installs (older than a day)
+  - pgx.old binaries of failed self-upgrades
+  - cached checkouts and builds not used within --older-than; builds of
+    installed or stored versions are kept
+  - cellar entries of extensions whose files are all gone
+
+Shared libraries in pkglibdir that no installed extension loads are
+reported, but not removed. Bottles in bottle_dir are left alone: pgx only
+reads that directory, so prune it where the bottles are made.
+
+Examples:
+  pgx cleanup --dry-run
+  pgx cleanup --older-than 168h
+  pgx cleanup --sudo  # Also fix the cellar of a system PostgreSQL`,
+	Args: cobra.NoArgs,
+	RunE: runCleanup,
+}
+
+func init() {
+	cleanupCmd.Flags().BoolVar(&cleanupDryRun, "dry-run", false, "Show what would be removed without deleting")
+	cleanupCmd.Flags().BoolVar(&cleanupUseSudo, "sudo", false, "Use sudo to update the cellar (needed for system PostgreSQL)")
+	cleanupCmd.Flags().DurationVar(&cleanupOlderThan, "older-than", 30*24*time.Hour, "Remove cached checkouts and builds not used within this duration")
+}
+
+// cleanupItem is something cleanup can remove.
+type cleanupItem struct {
+	desc   string
+	size   int64
+	remove func() error
+}
+
+func runCleanup(cmd *cobra.Command, args []string) error {
+	cellar.SetUseSudo(cleanupUseSudo)
+	cutoff := time.Now().Add(-cleanupOlderThan)
+
+	groups := []struct {
+		title string
+		find  func() ([]cleanupItem, error)
+	}{
+		{"Stale temporary directories", staleTempDirs},
+		{"Old pgx binaries", oldBinaries},
+		{fmt.Sprintf("Cached checkouts not used within %s", cleanupOlderThan), func() ([]cleanupItem, error) { return staleCheckouts(cutoff) }},
+		{fmt.Sprintf("Cached builds not used within %s", cleanupOlderThan), func() ([]cleanupItem, error) { return staleBuilds(cutoff) }},
+		{"Cellar entries whose files are gone", missingEntries},
+	}
+
+	var removed int
+	var freed int64
+	var errs []error
+	for _, g := range groups {
+		items, err := g.find()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", strings.ToLower(g.title), err))
+			continue
+		}
+		if len(items) == 0 {
+			continue
+		}
+
+		fmt.Printf("%s:\n", g.title)
+		for _, item := range items {
+			size := ""
+			if item.size > 0 {
+				size = fmt.Sprintf(" (%s)", formatSize(item.size))
+			}
+			if cleanupDryRun {
+				fmt.Printf("  - %s%s\n", item.desc, size)
+				continue
+			}
+			if err := item.remove(); err != nil {
+				fmt.Printf("  ✗ %s: %v\n", item.desc, err)
+				errs = append(errs, fmt.Errorf("%s: %w", item.desc, err))
+				continue
+			}
+			fmt.Printf("  - %s%s\n", item.desc, size)
+			removed++
+			freed += item.size
+		}
+		fmt.Println()
+	}
+
+	if !cleanupDryRun {
+		if err := github.PruneWorktrees(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	reportOrphanLibraries()
+
+	if cleanupDryRun {
+		fmt.Println("Dry run: nothing was removed.")
+	} else {
+		fmt.Printf("✓ Removed %d items (%s)\n", removed, formatSize(freed))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("cleanup incomplete:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// staleTempDirs finds the temporary directories of installs, builds and
+// stores that were interrupted.
+func staleTempDirs() ([]cleanupItem, error) {
+	dirs, err := filepath.Glob(filepath.Join(os.TempDir(), "pgbrew-*"))
+	if err != nil {
+		return nil, err
+	}
+	if buildTmp, err := cache.TempDirs(); err == nil {
+		dirs = append(dirs, buildTmp...)
+	}
+	if store, err := cellar.StoreDir(); err == nil {
+		kegTmp, _ := filepath.Glob(filepath.Join(store, "*", ".tmp-*"))
+		dirs = append(dirs, kegTmp...)
+	}
+
+	var items []cleanupItem
+	for _, dir := range dirs {
+		info, err := os.Stat(dir)
+		if err != nil || !info.IsDir() || time.Since(info.ModTime()) < staleTempAge {
+			continue
+		}
+		items = append(items, removeAllItem(dir))
+	}
+	return items, nil
+}
+
+// oldBinaries finds the previous pgx binary kept by an interrupted upgrade.
+func oldBinaries() ([]cleanupItem, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = resolved
+	}
+	info, err := os.Stat(exe + ".old")
+	if err != nil || info.IsDir() {
+		return nil, nil
+	}
+	old := exe + ".old"
+	return []cleanupItem{{desc: old, size: info.Size(), remove: func() error { return os.Remove(old) }}}, nil
+}
+
+func staleCheckouts(cutoff time.Time) ([]cleanupItem, error) {
+	clones, err := cache.StaleSources(cutoff)
+	if err != nil {
+		return nil, err
+	}
+	var items []cleanupItem
+	for _, clone := range clones {
+		clone := clone
+		items = append(items, cleanupItem{desc: clone, size: cache.Size(clone), remove: func() error { return cache.RemoveSource(clone) }})
+	}
+	return items, nil
+}
+
+// staleBuilds finds cached builds not used since cutoff. Builds of installed
+// or stored versions are kept, so reinstalls and switches stay fast and work
+// offline.
+func staleBuilds(cutoff time.Time) ([]cleanupItem, error) {
+	builds, err := cache.List()
+	if err != nil {
+		return nil, err
+	}
+
+	inUse := map[string]bool{}
+	entries, _ := cellar.List()
+	kegs, _ := cellar.Kegs("")
+	for _, k := range kegs {
+		entries = append(entries, k.Entry)
+	}
+	for _, e := range entries {
+		inUse[e.Name+"\x00"+e.Commit+"\x00"+e.PgVersion] = true
+	}
+
+	var items []cleanupItem
+	for _, b := range builds {
+		if b.LastUsedAt.After(cutoff) || inUse[b.Name+"\x00"+b.Commit+"\x00"+b.PgVersion] {
+			continue
+		}
+		b := b
+		items = append(items, cleanupItem{
+			desc:   fmt.Sprintf("%s %s %s (pg%s, last used %s)", b.Key, b.Name, b.Version, b.PgVersion, b.LastUsedAt.Format("2006-01-02")),
+			size:   cache.Size(b.Dir),
+			remove: func() error { return cache.Remove(b) },
+		})
+	}
+	return items, nil
+}
+
+// missingEntries finds cellar entries none of whose files exist any more,
+// e.g. after PostgreSQL was reinstalled or the files were deleted by hand.
+func missingEntries() ([]cleanupItem, error) {
+	entries, err := cellar.List()
+	if err != nil {
+		return nil, err
+	}
+
+	extDir := ""
+	if shareDir := strings.TrimSpace(getCommandOutput(getPgConfigPath(), "--sharedir")); shareDir != "" {
+		extDir = filepath.Join(shareDir, "extension")
+	}
+
+	var items []cleanupItem
+	for _, e := range entries {
+		files := e.Files
+		if len(files) == 0 {
+			if extDir == "" {
+				continue
+			}
+			// Entries from before file manifests were recorded
+			files = []string{filepath.Join(extDir, e.Name+".control")}
+		}
+		if anyExists(files) {
+			continue
+		}
+		name := e.Name
+		items = append(items, cleanupItem{
+			desc:   fmt.Sprintf("%s %s (%d files missing)", e.Name, e.Version, len(files)),
+			remove: func() error { return cellar.Remove(name) },
+		})
+	}
+	return items, nil
+}
+
+// reportOrphanLibraries lists the shared libraries in pkglibdir that neither
+// an installed extension nor PostgreSQL itself loads.
+func reportOrphanLibraries() {
+	pgConfigPath := getPgConfigPath()
+	libDir := strings.TrimSpace(getCommandOutput(pgConfigPath, "--pkglibdir"))
+	shareDir := strings.TrimSpace(getCommandOutput(pgConfigPath, "--sharedir"))
+	if libDir == "" || shareDir == "" {
+		return
+	}
+
+	suffix := builder.ModuleSuffix(pgConfigPath)
+	modules, err := control.Modules(filepath.Join(shareDir, "extension"), suffix)
+	if err != nil {
+		return
+	}
+	if entries, err := cellar.List(); err == nil {
+		for _, e := range entries {
+			modules[e.Name] = true
+			for _, f := range e.Files {
+				if builder.IsModule(f, suffix) {
+					modules[builder.ModuleName(f, suffix)] = true
+				}
+			}
+		}
+	}
+
+	libs, _ := filepath.Glob(filepath.Join(libDir, "*"+suffix))
+	var orphans []string
+	for _, lib := range libs {
+		name := builder.ModuleName(lib, suffix)
+		// Encoding conversions (utf8_and_sjis, ...) are part of PostgreSQL
+		if modules[name] || coreModules[name] || strings.Contains(name, "_and_") {
+			continue
+		}
+		orphans = append(orphans, lib)
+	}
+	if len(orphans) == 0 {
+		return
+	}
+
+	sort.Strings(orphans)
+	fmt.Printf("⚠ Libraries in %s that no installed extension loads:\n", libDir)
+	for _, lib := range orphans {
+		fmt.Printf("  - %s\n", filepath.Base(lib))
+	}
+	fmt.Println("  These may belong to an uninstalled extension or be loaded directly (e.g. via shared_preload_libraries); remove them by hand if unused.")
+	fmt.Println()
+}
+
+// removeAllItem removes a directory tree.
+func removeAllItem(dir string) cleanupItem {
+	return cleanupItem{desc: dir, size: cache.Size(dir), remove: func() error { return os.RemoveAll(dir) }}
+}
+
+func anyExists(files []string) bool {
+	for _, f := range files {
+		if _, err := os.Lstat(f); err == nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/matroidbe/pgbrew/internal/config"
+	"github.com/spf13/cobra"
+)
+
+var (
+	configScope      string
+	configShowOrigin bool
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Show and change pgx settings",
+	Long: `Show and change pgx settings.
+
+Settings are read from these layers; later ones take precedence:
+  /etc/pgbrew/config.toml         system-wide
+  ~/.config/pgbrew/config.toml    per user
+  ./.pgbrew.toml                  per project (current directory)
+  PGBREW_* environment variables  (and PG_CONFIG for pg_config)
+Command-line flags override all of them. A project's .pgbrew.toml can't set
+pg_config, aliases or mirrors, and release_url, release_public_key and
+history_file are only read from the system configuration.
+
+Example config.toml:
+  pg_config = "/usr/lib/postgresql/16/bin/pg_config"
+  sudo = true
+
+  [aliases]
+  vector = "github.com/pgvector/pgvector@v0.8.0"
+
+  [mirrors]
+  "github.com" = "https://git.example.com/github"`,
+}
+
+var configListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List effective settings and where they come from",
+	Args:  cobra.NoArgs,
+	RunE:  runConfigList,
+}
+
+var configGetCmd = &cobra.Command{
+	Use:   "get <key>",
+	Short: "Print the effective value of a setting",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runConfigGet,
+}
+
+var configSetCmd = &cobra.Command{
+	Use:   "set <key> <value>",
+	Short: "Write a setting to a config file",
+	Args:  cobra.ExactArgs(2),
+	RunE:  runConfigSet,
+}
+
+var configUnsetCmd = &cobra.Command{
+	Use:   "unset <key>",
+	Short: "Remove a setting from a config file",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runConfigUnset,
+}
+
+func init() {
+	configGetCmd.Flags().BoolVar(&configShowOrigin, "show-origin", false, "Also print where the value comes from")
+	for _, c := range []*cobra.Command{configSetCmd, configUnsetCmd} {
+		c.Flags().StringVar(&configScope, "scope", config.ScopeUser, "Config file to change: system, user or project")
+	}
+
+	configCmd.AddCommand(configListCmd)
+	configCmd.AddCommand(configGetCmd)
+	configCmd.AddCommand(configSetCmd)
+	configCmd.AddCommand(configUnsetCmd)
+}
+
+func runConfigList(cmd *cobra.Command, args []string) error {
+	settings := config.All()
+	if jsonOutput() {
+		return printJSON(settings)
+	}
+
+	for _, s := range settings {
+		value := s.Value
+		if value == "" {
+			value = "(unset)"
+		}
+		fmt.Printf("%-24s %-40s %s\n", s.Key, value, s.Source)
+	}
+
+	fmt.Println()
+	fmt.Println("Keys:")
+	for _, h := range config.Help() {
+		fmt.Printf("  %-16s %s\n", h[0], h[1])
+	}
+	return nil
+}
+
+func runConfigGet(cmd *cobra.Command, args []string) error {
+	s, ok := config.Get(args[0])
+	if !ok {
+		return fmt.Errorf("%s is not set", args[0])
+	}
+	if configShowOrigin {
+		fmt.Printf("%s\t%s\n", s.Value, s.Source)
+	} else {
+		fmt.Println(s.Value)
+	}
+	return nil
+}
+
+func runConfigSet(cmd *cobra.Command, args []string) error {
+	if err := config.Set(configScope, args[0], args[1]); err != nil {
+		return err
+	}
+	path, _ := config.Path(configScope)
+	fmt.Printf("✓ Set %s = %s in %s\n", args[0], args[1], path)
+	return nil
+}
+
+func runConfigUnset(cmd *cobra.Command, args []string) error {
+	if err := config.Unset(configScope, args[0]); err != nil {
+		return err
+	}
+	path, _ := config.Path(configScope)
+	fmt.Printf("✓ Removed %s from %s\n", args[0], path)
+	return nil
+}
+
+// jsonOutput reports whether the output setting asks for JSON.
+func jsonOutput() bool {
+	return config.String("output") == "json"
+}
+
+// printJSON writes v to stdout as indented JSON.
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
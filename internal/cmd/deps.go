@@ -0,0 +1,222 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/matroidbe/pgbrew/internal/cellar"
+	"github.com/matroidbe/pgbrew/internal/control"
+	"github.com/spf13/cobra"
+)
+
+var (
+	depsTree bool
+	depsDot  bool
+)
+
+var depsCmd = &cobra.Command{
+	Use:   "deps <extension>",
+	Short: "Show the extensions an extension requires",
+	Long: `Show the extensions an extension requires, directly or not, from the
+requires setting of the installed control files (pgx and non-pgx alike),
+in the order they have to be created.
+
+Examples:
+  pgx deps postgis_topology
+  pgx deps --tree postgis_topology
+  pgx deps --dot postgis_topology | dot -Tsvg > deps.svg`,
+	Args: cobra.ExactArgs(1),
+	RunE: runDeps,
+}
+
+var usesCmd = &cobra.Command{
+	Use:   "uses <extension>",
+	Short: "Show the extensions that require an extension",
+	Long: `Show the installed extensions that require an extension, directly or not.
+
+Examples:
+  pgx uses postgis
+  pgx uses --tree postgis
+  pgx uses --dot postgis | dot -Tsvg > uses.svg`,
+	Args: cobra.ExactArgs(1),
+	RunE: runUses,
+}
+
+func init() {
+	for _, c := range []*cobra.Command{depsCmd, usesCmd} {
+		c.Flags().BoolVar(&depsTree, "tree", false, "Show the dependencies as a tree")
+		c.Flags().BoolVar(&depsDot, "dot", false, "Print the graph in Graphviz dot format")
+	}
+}
+
+func runDeps(cmd *cobra.Command, args []string) error {
+	name := args[0]
+	g, labels, err := loadExtensionGraph()
+	if err != nil {
+		return err
+	}
+	if !g.Has(name) {
+		return fmt.Errorf("extension not found: %s", name)
+	}
+
+	switch {
+	case depsDot:
+		printDot(g, append(g.Deps(name), name))
+	case depsTree:
+		fmt.Println(name + labels[name])
+		printTree(g.Requires, labels, name, "", map[string]bool{name: true})
+	default:
+		deps := g.Deps(name)
+		if len(deps) == 0 {
+			fmt.Printf("%s requires no other extensions\n", name)
+			return nil
+		}
+		fmt.Printf("%s requires (in creation order):\n", name)
+		for _, dep := range deps {
+			fmt.Printf("  %s%s\n", dep, labels[dep])
+		}
+	}
+	return nil
+}
+
+func runUses(cmd *cobra.Command, args []string) error {
+	name := args[0]
+	g, labels, err := loadExtensionGraph()
+	if err != nil {
+		return err
+	}
+	if !g.Has(name) && len(g.RequiredBy(name)) == 0 {
+		return fmt.Errorf("extension not found: %s", name)
+	}
+
+	switch {
+	case depsDot:
+		printDot(g, append(g.Dependents(name), name))
+	case depsTree:
+		fmt.Println(name + labels[name])
+		printTree(g.RequiredBy, labels, name, "", map[string]bool{name: true})
+	default:
+		dependents := g.Dependents(name)
+		if len(dependents) == 0 {
+			fmt.Printf("No installed extension requires %s\n", name)
+			return nil
+		}
+		direct := map[string]bool{}
+		for _, d := range g.RequiredBy(name) {
+			direct[d] = true
+		}
+		fmt.Printf("%s is required by:\n", name)
+		for _, d := range dependents {
+			indirect := ""
+			if !direct[d] {
+				indirect = " (indirectly)"
+			}
+			fmt.Printf("  %s%s%s\n", d, labels[d], indirect)
+		}
+	}
+	return nil
+}
+
+// loadExtensionGraph returns the dependency graph of the installed
+// extensions, and a label for each with its version and whether pgx
+// installed it.
+func loadExtensionGraph() (*control.Graph, map[string]string, error) {
+	extDir := extensionDir()
+	if extDir == "" {
+		return nil, nil, fmt.Errorf("could not determine PostgreSQL directories")
+	}
+	g, err := control.LoadGraph(extDir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read control files: %w", err)
+	}
+
+	labels := map[string]string{}
+	controls, _ := control.List(extDir)
+	for _, c := range controls {
+		if c.DefaultVersion != "" {
+			labels[c.Name] = " " + c.DefaultVersion
+		}
+		for _, r := range c.Requires {
+			if !g.Has(r) {
+				labels[r] = " ✗ not installed"
+			}
+		}
+	}
+	if entries, err := cellar.List(); err == nil {
+		for _, e := range entries {
+			labels[e.Name] = fmt.Sprintf(" %s (pgx)", e.Version)
+		}
+	}
+	return g, labels, nil
+}
+
+// printTree prints the edges below name, as given by next. An extension that
+// already appeared above is not expanded again.
+func printTree(next func(string) []string, labels map[string]string, name string, indent string, seen map[string]bool) {
+	children := next(name)
+	for i, child := range children {
+		branch, more := "├── ", "│   "
+		if i == len(children)-1 {
+			branch, more = "└── ", "    "
+		}
+		if seen[child] {
+			fmt.Printf("%s%s%s%s (see above)\n", indent, branch, child, labels[child])
+			continue
+		}
+		fmt.Printf("%s%s%s%s\n", indent, branch, child, labels[child])
+		seen[child] = true
+		printTree(next, labels, child, indent+more, seen)
+	}
+}
+
+// printDot prints the requires edges between the given extensions in
+// Graphviz dot format. Extensions that are not installed are dashed.
+func printDot(g *control.Graph, names []string) {
+	include := map[string]bool{}
+	for _, name := range names {
+		include[name] = true
+	}
+
+	fmt.Println("digraph extensions {")
+	fmt.Println("  rankdir=LR;")
+	for _, name := range names {
+		style := ""
+		if !g.Has(name) {
+			style = " [style=dashed]"
+		}
+		fmt.Printf("  %s%s;\n", dotID(name), style)
+	}
+	for _, name := range names {
+		for _, r := range g.Requires(name) {
+			if include[r] {
+				fmt.Printf("  %s -> %s;\n", dotID(name), dotID(r))
+			}
+		}
+	}
+	fmt.Println("}")
+}
+
+// dotID quotes a name for dot.
+func dotID(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `\"`) + `"`
+}
+
+// orderEntries sorts cellar entries so that each extension is installed
+// after the ones it requires, by the control files in extDir.
+func orderEntries(entries []cellar.Entry, extDir string) []cellar.Entry {
+	g, err := control.LoadGraph(extDir)
+	if err != nil {
+		return entries
+	}
+	byName := map[string]cellar.Entry{}
+	var names []string
+	for _, e := range entries {
+		byName[e.Name] = e
+		names = append(names, e.Name)
+	}
+	ordered := make([]cellar.Entry, 0, len(entries))
+	for _, name := range g.Sort(names) {
+		ordered = append(ordered, byName[name])
+	}
+	return ordered
+}
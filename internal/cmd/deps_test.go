@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/matroidbe/pgbrew/internal/cellar"
+)
+
+func TestOrderEntries(t *testing.T) {
+	extDir := t.TempDir()
+	controls := map[string]string{
+		"postgis":        "default_version = '3.4.0'\n",
+		"postgis_raster": "default_version = '3.4.0'\nrequires = 'postgis'\n",
+		"postgis_tiger":  "default_version = '3.4.0'\nrequires = 'postgis_raster, fuzzystrmatch'\n",
+		"fuzzystrmatch":  "default_version = '1.2'\n",
+		"standalone":     "default_version = '1.0'\n",
+	}
+	for name, content := range controls {
+		if err := os.WriteFile(filepath.Join(extDir, name+".control"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		extDir string
+		names  []string
+		want   []string
+	}{
+		{extDir, []string{"postgis_tiger", "postgis"}, []string{"postgis", "postgis_tiger"}},
+		{extDir, []string{"postgis_tiger", "postgis_raster", "postgis"}, []string{"postgis", "postgis_raster", "postgis_tiger"}},
+		{extDir, []string{"standalone", "postgis_raster", "postgis"}, []string{"standalone", "postgis", "postgis_raster"}},
+		{extDir, []string{"unknown", "postgis"}, []string{"unknown", "postgis"}},
+		{filepath.Join(extDir, "missing"), []string{"postgis_raster", "postgis"}, []string{"postgis_raster", "postgis"}},
+		{extDir, nil, nil},
+	}
+	for _, tt := range tests {
+		var entries []cellar.Entry
+		for _, name := range tt.names {
+			entries = append(entries, cellar.Entry{Name: name, Version: name + "-version"})
+		}
+		var got []string
+		for _, e := range orderEntries(entries, tt.extDir) {
+			if e.Version != e.Name+"-version" {
+				t.Errorf("orderEntries(%v) lost the entry of %s", tt.names, e.Name)
+			}
+			got = append(got, e.Name)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("orderEntries(%v) = %v, want %v", tt.names, got, tt.want)
+		}
+	}
+}
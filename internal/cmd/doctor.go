@@ -7,14 +7,20 @@ import (
 	"runtime"
 	"strings"
 
+	"github.com/matroidbe/pgbrew/internal/builder"
+	"github.com/matroidbe/pgbrew/internal/cellar"
+	"github.com/matroidbe/pgbrew/internal/config"
+	"github.com/matroidbe/pgbrew/internal/offline"
+	"github.com/matroidbe/pgbrew/internal/sharedlib"
 	"github.com/spf13/cobra"
 )
 
 var fixFlag bool
 
-// getPgConfigPath returns the path to pg_config, checking PG_CONFIG env var first
+// getPgConfigPath returns the path to pg_config from the pg_config setting
+// (PGBREW_PG_CONFIG or PG_CONFIG, the config files, or the default)
 func getPgConfigPath() string {
-	if pgConfig := os.Getenv("PG_CONFIG"); pgConfig != "" {
+	if pgConfig := config.String("pg_config"); pgConfig != "" {
 		return pgConfig
 	}
 	return "pg_config"
@@ -119,8 +125,8 @@ func runDoctor(cmd *cobra.Command, args []string) {
 		pgShareDir := getCommandOutput(pgConfigPath, "--sharedir")
 		fmt.Printf("  Library dir: %s\n", strings.TrimSpace(pgLibDir))
 		fmt.Printf("  Share dir: %s\n", strings.TrimSpace(pgShareDir))
-		if os.Getenv("PG_CONFIG") != "" {
-			fmt.Printf("  Using PG_CONFIG: %s\n", pgConfigPath)
+		if setting, _ := config.Get("pg_config"); setting.Source != "default" {
+			fmt.Printf("  Using pg_config: %s (from %s)\n", pgConfigPath, setting.Source)
 		}
 	} else {
 		fmt.Println("✗ PostgreSQL: pg_config not found")
@@ -198,6 +204,43 @@ func runDoctor(cmd *cobra.Command, args []string) {
 		fmt.Printf("  Install: %s\n", getInstallHint("build-essential"))
 	}
 
+	// Check the compiler PostgreSQL was built with, and the LLVM bitcode tools
+	if pgMajorVersion != "" {
+		cc, err := builder.DetectCompiler(pgConfigPath, "")
+		if err != nil {
+			fmt.Printf("✗ Build compiler: %v\n", err)
+			fmt.Printf("  Install: %s\n", getInstallHint("build-essential"))
+		} else if cc.Fallback() {
+			fmt.Printf("✓ Build compiler: %s (pg_config has %s, which is not installed)\n", cc.CC, cc.Configured)
+		} else {
+			fmt.Printf("✓ Build compiler: %s (from pg_config)\n", cc.CC)
+		}
+
+		if llvm := builder.DetectLLVM(pgConfigPath); llvm.Enabled {
+			if len(llvm.Missing) > 0 {
+				fmt.Printf("✗ LLVM bitcode tools: %s not found (JIT bitcode will be skipped)\n", strings.Join(llvm.Missing, ", "))
+				fmt.Printf("  Install: %s\n", getInstallHint("clang llvm"))
+			} else {
+				fmt.Printf("✓ LLVM bitcode tools: %s, %s\n", llvm.Clang, llvm.LLVMLTO)
+			}
+		}
+	}
+
+	// Check that installed extensions can load their system libraries
+	if entries, err := cellar.List(); err == nil && len(entries) > 0 {
+		fmt.Println()
+		fmt.Println("Installed extensions:")
+		for _, entry := range entries {
+			if missing := missingLibraries(entry); len(missing) > 0 {
+				fmt.Printf("✗ %s: missing system libraries\n", entry.Name)
+				printMissingLibraries(missing, "  ")
+				allOk = false
+			} else {
+				fmt.Printf("✓ %s: system libraries found\n", entry.Name)
+			}
+		}
+	}
+
 	fmt.Println()
 	if fixFlag && fixedCount > 0 {
 		fmt.Printf("Fixed %d issue(s).\n", fixedCount)
@@ -228,6 +271,10 @@ func getCommandOutput(name string, args ...string) string {
 
 // installRust installs Rust via rustup
 func installRust() error {
+	if offline.Enabled() {
+		return offline.Missing("the Rust toolchain", "Install it from https://rustup.rs/ while connected.")
+	}
+
 	fmt.Println("  → Installing Rust via rustup...")
 
 	// Download and run rustup installer with -y for non-interactive
@@ -252,6 +299,10 @@ func installRust() error {
 
 // installCargoPgrx installs cargo-pgrx
 func installCargoPgrx() error {
+	if offline.Enabled() {
+		return offline.Missing("cargo-pgrx", "Install it with 'cargo install cargo-pgrx --locked' while connected.")
+	}
+
 	fmt.Println("  → Installing cargo-pgrx...")
 
 	cmd := exec.Command("cargo", "install", "cargo-pgrx", "--locked")
@@ -285,22 +336,56 @@ func initPgrx(pgMajorVersion, pgConfigPath string) error {
 
 // getInstallHint returns the install command for system packages based on OS
 func getInstallHint(pkg string) string {
+	switch distroFamily() {
+	case sharedlib.Darwin:
+		return fmt.Sprintf("brew install %s", pkg)
+	case sharedlib.Debian:
+		return fmt.Sprintf("sudo apt install %s", pkg)
+	case sharedlib.RedHat:
+		return fmt.Sprintf("sudo dnf install %s", pkg)
+	case sharedlib.Arch:
+		return fmt.Sprintf("sudo pacman -S %s", pkg)
+	default:
+		return fmt.Sprintf("Install %s using your package manager", pkg)
+	}
+}
+
+// distroFamily returns the operating system or Linux distribution family
+// of this host, for package hints ("" if unknown).
+func distroFamily() string {
 	switch runtime.GOOS {
 	case "darwin":
-		return fmt.Sprintf("brew install %s", pkg)
+		return sharedlib.Darwin
 	case "linux":
 		// Check for common distros
 		if _, err := os.Stat("/etc/debian_version"); err == nil {
-			return fmt.Sprintf("sudo apt install %s", pkg)
+			return sharedlib.Debian
 		}
 		if _, err := os.Stat("/etc/redhat-release"); err == nil {
-			return fmt.Sprintf("sudo dnf install %s", pkg)
+			return sharedlib.RedHat
 		}
 		if _, err := os.Stat("/etc/arch-release"); err == nil {
-			return fmt.Sprintf("sudo pacman -S %s", pkg)
+			return sharedlib.Arch
 		}
-		return fmt.Sprintf("Install %s using your package manager", pkg)
+	}
+	return ""
+}
+
+// libraryInstallHint suggests how to install the package providing a shared
+// library, or how to find that package if it isn't a common one.
+func libraryInstallHint(soname string) string {
+	family := distroFamily()
+	if pkg := sharedlib.Package(soname, family); pkg != "" {
+		return "Install: " + getInstallHint(pkg)
+	}
+	switch family {
+	case sharedlib.Debian:
+		return fmt.Sprintf("Find the package with: apt-file search %s", soname)
+	case sharedlib.RedHat:
+		return fmt.Sprintf("Find the package with: dnf provides '*/%s'", soname)
+	case sharedlib.Arch:
+		return fmt.Sprintf("Find the package with: pacman -F %s", soname)
 	default:
-		return fmt.Sprintf("Install %s using your package manager", pkg)
+		return fmt.Sprintf("Install: the package that provides %s", soname)
 	}
 }
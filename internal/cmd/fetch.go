@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/matroidbe/pgbrew/internal/cache"
+	"github.com/matroidbe/pgbrew/internal/github"
+	"github.com/matroidbe/pgbrew/internal/offline"
+	"github.com/matroidbe/pgbrew/internal/pgrx"
+	"github.com/spf13/cobra"
+)
+
+var fetchCmd = &cobra.Command{
+	Use:   "fetch <source>...",
+	Short: "Download sources for later offline installation",
+	Long: `Download extension sources into the local cache without building them.
+
+Each repository is fetched into its git mirror and the requested ref is
+checked out. For pgrx extensions, crate dependencies are downloaded into
+cargo's registry cache as well.
+
+Run this on a connected machine, then copy the cache to an air-gapped host
+and install there with --offline.
+
+Examples:
+  pgx fetch github.com/pgvector/pgvector@v0.8.0
+  pgx fetch github.com/paradedb/paradedb/pg_search@v0.15.0`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runFetch,
+}
+
+func runFetch(cmd *cobra.Command, args []string) error {
+	if offline.Enabled() {
+		return fmt.Errorf("pgx fetch needs network access and cannot run in offline mode")
+	}
+
+	for _, source := range args {
+		resolved, _, err := resolveSource(source)
+		if err != nil {
+			return err
+		}
+		if err := fetchSource(resolved); err != nil {
+			return fmt.Errorf("failed to fetch %s: %w", source, err)
+		}
+	}
+
+	cacheDir, _ := cache.Dir()
+	home, _ := os.UserHomeDir()
+	fmt.Println()
+	fmt.Println("To install offline, copy these directories to the same paths on the target host:")
+	fmt.Printf("  %s\n", filepath.Join(cacheDir, "git"))
+	fmt.Printf("  %s  (pgrx extensions only)\n", filepath.Join(home, ".cargo", "registry"))
+	fmt.Println("Prebuilt builds from 'pgx install' on a matching host can be shipped too:")
+	fmt.Printf("  %s  (use with --bottle-dir)\n", filepath.Join(cacheDir, "builds"))
+	return nil
+}
+
+// fetchSource downloads a single GitHub source and its build dependencies.
+func fetchSource(source string) error {
+	repo, subpath, version, err := github.ParseURL(source)
+	if err != nil {
+		return fmt.Errorf("invalid source: %w", err)
+	}
+
+	mirror, err := github.UpdateMirror(repo, nil)
+	if err != nil {
+		return err
+	}
+	commit, err := github.ResolveRef(mirror, version)
+	if err != nil {
+		return err
+	}
+
+	src := &sourceTree{Source: source, Repo: repo, Subpath: subpath, Ref: version, Commit: commit}
+	worktree, err := checkoutWorktree(src, mirror)
+	if err != nil {
+		return err
+	}
+	src.Root = worktree
+	src.Dir = filepath.Join(worktree, subpath)
+	if err := loadManifest(src); err != nil {
+		return err
+	}
+	extDir := src.Dir
+
+	if pgrx.IsProject(extDir) {
+		fmt.Println("Fetching crate dependencies...")
+		if err := pgrx.Fetch(extDir); err != nil {
+			return err
+		}
+		if required, err := pgrx.GetPgrxVersion(extDir); err == nil {
+			fmt.Printf("  Requires cargo-pgrx %s; install it on the target host before going offline\n", required)
+		}
+	}
+
+	fmt.Printf("✓ Fetched %s@%s\n", repo, shortSHA(commit))
+	return nil
+}
@@ -0,0 +1,165 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/matroidbe/pgbrew/internal/cellar"
+	"github.com/matroidbe/pgbrew/internal/history"
+	"github.com/spf13/cobra"
+)
+
+var historyLimit int
+
+var historyCmd = &cobra.Command{
+	Use:   "history [extension]",
+	Short: "Show the history of installs and other changes",
+	Long: `Show the changes pgx made to installed extensions: who ran what, when and
+on which host, the source, commit and versions before and after, the
+PostgreSQL target, whether sudo was used, and the outcome.
+
+The history is kept in append-only JSON Lines files. Changes made with sudo
+or as root (to a system PostgreSQL) go to /var/log/pgbrew/history.jsonl,
+shared by every administrator; other changes go to
+~/.local/state/pgbrew/history.jsonl. Both are shown. Set history_file in the
+system configuration (/etc/pgbrew/config.toml) to use a single file for
+everything.
+
+Examples:
+  pgx history
+  pgx history vector
+  PGBREW_OUTPUT=json pgx history`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runHistory,
+}
+
+func init() {
+	historyCmd.Flags().IntVarP(&historyLimit, "limit", "n", 0, "Only show the last N records")
+}
+
+func runHistory(cmd *cobra.Command, args []string) error {
+	name := ""
+	if len(args) == 1 {
+		name = args[0]
+	}
+	records, err := history.Read(name)
+	if err != nil {
+		return fmt.Errorf("failed to read history: %w", err)
+	}
+	if historyLimit > 0 && len(records) > historyLimit {
+		records = records[len(records)-historyLimit:]
+	}
+
+	if jsonOutput() {
+		return printJSON(records)
+	}
+	if len(records) == 0 {
+		fmt.Println("No history recorded.")
+		return nil
+	}
+
+	for _, r := range records {
+		versions := r.VersionAfter
+		if r.VersionBefore != r.VersionAfter {
+			versions = fmt.Sprintf("%s → %s", orDash(r.VersionBefore), orDash(r.VersionAfter))
+		}
+		marker := "✓"
+		if r.Outcome != history.Success {
+			marker = "✗"
+		}
+		fmt.Printf("%s %s  %-9s %-20s %-16s %s@%s\n", marker, r.Time.Format("2006-01-02 15:04:05"), r.Action, r.Extension, versions, r.User, r.Host)
+
+		details := []string{"pg" + orDash(r.PgVersion)}
+		if r.Source != "" {
+			details = append(details, r.Source)
+		}
+		if r.Commit != "" {
+			details = append(details, shortSHA(r.Commit))
+		}
+		if r.Sudo {
+			details = append(details, "sudo")
+		}
+		fmt.Printf("    %s\n", strings.Join(details, "  "))
+		fmt.Printf("    $ %s\n", r.Command)
+		if r.Error != "" {
+			fmt.Printf("    %s\n", strings.SplitN(r.Error, "\n", 2)[0])
+		}
+	}
+	return nil
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// change records a command's effect on an extension in the history.
+type change struct {
+	action string
+	sudo   bool
+	before map[string]cellar.Entry
+}
+
+// beginChange remembers the installed extensions before a command changes
+// them.
+func beginChange(action string, sudo bool) *change {
+	c := &change{action: action, sudo: sudo, before: map[string]cellar.Entry{}}
+	if entries, err := cellar.List(); err == nil {
+		for _, e := range entries {
+			c.before[e.Name] = e
+		}
+	}
+	return c
+}
+
+// record appends the outcome for an extension to the history. source is the
+// source the command asked for ("" to use the one recorded in the cellar).
+func (c *change) record(name string, source string, err error) {
+	r := history.Record{
+		Command:   commandLine(),
+		Action:    c.action,
+		Extension: name,
+		Source:    source,
+		PgConfig:  getPgConfigPath(),
+		PgVersion: getPgVersion(),
+		Sudo:      c.sudo,
+		Outcome:   history.Success,
+	}
+	if before, ok := c.before[name]; ok {
+		r.VersionBefore = before.Version
+		r.Commit = before.Commit
+		if r.Source == "" {
+			r.Source = before.Source
+		}
+	}
+	if after, err := cellar.Get(name); err == nil {
+		r.VersionAfter = after.Version
+		r.Commit = after.Commit
+		if source == "" {
+			r.Source = after.Source
+		}
+	}
+	if err != nil {
+		r.Outcome = history.Failure
+		r.Error = err.Error()
+	}
+
+	if err := history.Append(r); err != nil {
+		fmt.Printf("⚠ Could not record history: %v\n", err)
+	}
+}
+
+// commandLine returns the command line pgx was run with.
+func commandLine() string {
+	args := make([]string, len(os.Args))
+	for i, arg := range os.Args {
+		if arg == "" || strings.ContainsAny(arg, " \t\"'") {
+			arg = fmt.Sprintf("%q", arg)
+		}
+		args[i] = arg
+	}
+	return strings.Join(args, " ")
+}
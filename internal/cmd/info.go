@@ -2,31 +2,265 @@ package cmd
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
 
+	"github.com/matroidbe/pgbrew/internal/builder"
 	"github.com/matroidbe/pgbrew/internal/cellar"
+	"github.com/matroidbe/pgbrew/internal/control"
 	"github.com/spf13/cobra"
 )
 
 var infoCmd = &cobra.Command{
 	Use:   "info <extension>",
 	Short: "Show extension information",
-	Args:  cobra.ExactArgs(1),
-	RunE:  runInfo,
+	Long: `Show what pgx knows about an extension: how it was installed (source,
+build system, commit, build options), its files with their sizes, its control
+file metadata, the versions and update paths its SQL scripts provide,
+whether it must be in shared_preload_libraries and whether it is, and the
+version created in each database.
+
+Extensions not installed by pgx are described from their control file.
+
+Examples:
+  pgx info vector
+  PGBREW_OUTPUT=json pgx info vector`,
+	Args: cobra.ExactArgs(1),
+	RunE: runInfo,
+}
+
+// extensionDetails is everything known about an extension. The cellar entry's
+// fields are at the top level, as before the other details were added.
+type extensionDetails struct {
+	*cellar.Entry
+	Name        string            `json:"name"`
+	Tracked     bool              `json:"tracked"` // Installed by pgx
+	Control     *control.Control  `json:"control,omitempty"`
+	FileSizes   map[string]int64  `json:"file_sizes"` // -1 for missing files
+	Installable []string          `json:"installable_versions,omitempty"`
+	Updates     []control.Update  `json:"update_paths,omitempty"`
+	Stored      []string          `json:"stored_versions,omitempty"`
+	Preload     preloadInfo       `json:"preload"`
+	Databases   map[string]string `json:"databases,omitempty"` // Version created in each database
+	DatabasesOK bool              `json:"databases_checked"`   // false if the server could not be asked
+}
+
+type preloadInfo struct {
+	Required   bool     `json:"required"`
+	Configured *bool    `json:"configured,omitempty"` // nil if the server could not be asked
+	Libraries  []string `json:"libraries,omitempty"`  // Matching shared_preload_libraries entries
 }
 
 func runInfo(cmd *cobra.Command, args []string) error {
 	name := args[0]
 
-	entry, err := cellar.Get(name)
-	if err != nil {
+	pgConfigPath := getPgConfigPath()
+	libDir := strings.TrimSpace(getCommandOutput(pgConfigPath, "--pkglibdir"))
+	shareDir := strings.TrimSpace(getCommandOutput(pgConfigPath, "--sharedir"))
+	if libDir == "" || shareDir == "" {
+		return fmt.Errorf("could not determine PostgreSQL directories")
+	}
+	extDir := filepath.Join(shareDir, "extension")
+	suffix := builder.ModuleSuffix(pgConfigPath)
+
+	info := extensionDetails{Name: name, FileSizes: map[string]int64{}}
+	if entry, err := cellar.Get(name); err == nil {
+		info.Entry = entry
+		info.Tracked = true
+	}
+	if c, err := control.Parse(filepath.Join(extDir, name+".control")); err == nil {
+		info.Control = c
+	}
+	if info.Entry == nil && info.Control == nil {
 		return fmt.Errorf("extension not found: %s", name)
 	}
 
-	fmt.Printf("Name:        %s\n", entry.Name)
-	fmt.Printf("Version:     %s\n", entry.Version)
-	fmt.Printf("Source:      %s\n", entry.Source)
-	fmt.Printf("PostgreSQL:  %s\n", entry.PgVersion)
-	fmt.Printf("Installed:   %s\n", entry.InstalledAt.Format("2006-01-02 15:04:05"))
+	// Files: the recorded manifest, or the usual files of the extension
+	var files []string
+	if info.Entry != nil && len(info.Entry.Files) > 0 {
+		files = info.Entry.Files
+	} else {
+		files = guessExtensionFiles(name, libDir, extDir, suffix)
+		if info.Control != nil && info.Control.ModulePathname != "" {
+			module := filepath.Join(libDir, builder.ModuleName(info.Control.ModulePathname, suffix)+suffix)
+			if _, err := os.Stat(module); err == nil && !slices.Contains(files, module) {
+				files = append(files, module)
+			}
+		}
+	}
+	for _, f := range files {
+		if fi, err := os.Stat(f); err == nil {
+			info.FileSizes[f] = fi.Size()
+		} else {
+			info.FileSizes[f] = -1
+		}
+	}
+
+	if info.Control != nil {
+		info.Installable, info.Updates, _ = control.Scripts(info.Control.ScriptDir(shareDir), name)
+	}
+	if kegs, err := cellar.Kegs(name); err == nil {
+		for _, k := range kegs {
+			info.Stored = append(info.Stored, k.Version)
+		}
+	}
+
+	// Preload: recorded at install, or inspected from the modules
+	if info.Entry != nil {
+		info.Preload.Required = info.Entry.SharedPreload
+	} else {
+		var modules []string
+		for _, f := range files {
+			if builder.IsModule(f, suffix) {
+				modules = append(modules, f)
+			}
+		}
+		info.Preload.Required, _ = builder.ModulesNeedSharedPreload(modules)
+	}
+	if preloaded, err := preloadedModules(name, files, suffix); err == nil {
+		configured := len(preloaded) > 0
+		info.Preload.Configured = &configured
+		info.Preload.Libraries = preloaded
+	}
 
+	if versions, err := databaseVersions(name); err == nil {
+		info.Databases, info.DatabasesOK = versions, true
+	}
+
+	if jsonOutput() {
+		return printJSON(info)
+	}
+	printInfo(info)
 	return nil
 }
+
+func printInfo(info extensionDetails) {
+	entry, c := info.Entry, info.Control
+
+	fmt.Printf("Name:        %s\n", info.Name)
+	switch {
+	case entry != nil:
+		fmt.Printf("Version:     %s\n", entry.Version)
+	case c.DefaultVersion != "":
+		fmt.Printf("Version:     %s (default_version)\n", c.DefaultVersion)
+	}
+	if c != nil && c.Comment != "" {
+		fmt.Printf("Comment:     %s\n", c.Comment)
+	}
+
+	if entry == nil {
+		fmt.Println("Installed:   not by pgx")
+	} else {
+		fmt.Printf("Source:      %s\n", entry.Source)
+		if entry.Formula != "" {
+			fmt.Printf("Formula:     %s\n", entry.Formula)
+		}
+		if entry.BuildSystem != "" {
+			build := entry.BuildSystem
+			if entry.Toolchain != "" {
+				build += ", " + entry.Toolchain
+			}
+			fmt.Printf("Build:       %s\n", build)
+		}
+		if entry.Commit != "" {
+			fmt.Printf("Commit:      %s\n", entry.Commit)
+		}
+		if entry.Options != nil && !entry.Options.IsEmpty() {
+			fmt.Printf("Options:     %s\n", entry.Options)
+		}
+		fmt.Printf("PostgreSQL:  %s\n", entry.PgVersion)
+		if entry.Pinned != "" {
+			fmt.Printf("Pinned:      %s\n", entry.Pinned)
+		}
+		fmt.Printf("Installed:   %s\n", entry.InstalledAt.Format("2006-01-02 15:04:05"))
+		if len(info.Stored) > 1 {
+			var versions []string
+			for _, v := range info.Stored {
+				if v == entry.Version {
+					v += " (linked)"
+				}
+				versions = append(versions, v)
+			}
+			fmt.Printf("Versions:    %s\n", strings.Join(versions, ", "))
+		}
+	}
+
+	if c != nil {
+		fmt.Printf("Control:     %s\n", c.Path)
+		if len(c.Requires) > 0 {
+			fmt.Printf("  Requires:    %s\n", strings.Join(c.Requires, ", "))
+		}
+		if c.Schema != "" {
+			fmt.Printf("  Schema:      %s\n", c.Schema)
+		}
+		fmt.Printf("  Relocatable: %s\n", yesNo(c.Relocatable))
+		fmt.Printf("  Superuser:   %s\n", yesNo(c.Superuser))
+		fmt.Printf("  Trusted:     %s\n", yesNo(c.Trusted))
+		if len(info.Installable) > 0 {
+			fmt.Printf("  Creates:     %s\n", strings.Join(info.Installable, ", "))
+		}
+		if len(info.Updates) > 0 {
+			var paths []string
+			for _, u := range info.Updates {
+				paths = append(paths, u.From+" → "+u.To)
+			}
+			fmt.Printf("  Updates:     %s\n", strings.Join(paths, ", "))
+		}
+	}
+
+	preload := "not required"
+	if info.Preload.Required {
+		preload = "required"
+	}
+	switch {
+	case info.Preload.Configured == nil:
+		preload += ", could not check shared_preload_libraries"
+	case *info.Preload.Configured:
+		preload += ", in shared_preload_libraries"
+	case info.Preload.Required:
+		preload += fmt.Sprintf(", but not in shared_preload_libraries (add '%s' and restart PostgreSQL)", info.Name)
+	}
+	fmt.Printf("Preload:     %s\n", preload)
+
+	switch {
+	case !info.DatabasesOK:
+		fmt.Println("Databases:   could not check")
+	case len(info.Databases) > 0:
+		var dbs []string
+		for db, version := range info.Databases {
+			dbs = append(dbs, fmt.Sprintf("%s (%s)", db, version))
+		}
+		sort.Strings(dbs)
+		fmt.Printf("Databases:   %s\n", strings.Join(dbs, ", "))
+	default:
+		fmt.Println("Databases:   none")
+	}
+
+	var paths []string
+	var total int64
+	for f, size := range info.FileSizes {
+		paths = append(paths, f)
+		if size > 0 {
+			total += size
+		}
+	}
+	sort.Strings(paths)
+	fmt.Printf("Files:       %d (%s)\n", len(paths), formatSize(total))
+	for _, f := range paths {
+		if size := info.FileSizes[f]; size < 0 {
+			fmt.Printf("  ✗ %s (missing)\n", f)
+		} else {
+			fmt.Printf("  %-60s %10s\n", f, formatSize(size))
+		}
+	}
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
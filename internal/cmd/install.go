@@ -4,19 +4,44 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/matroidbe/pgbrew/internal/builder"
+	"github.com/matroidbe/pgbrew/internal/buildlog"
+	"github.com/matroidbe/pgbrew/internal/cache"
 	"github.com/matroidbe/pgbrew/internal/cellar"
+	"github.com/matroidbe/pgbrew/internal/config"
 	"github.com/matroidbe/pgbrew/internal/github"
+	"github.com/matroidbe/pgbrew/internal/manifest"
+	"github.com/matroidbe/pgbrew/internal/offline"
+	"github.com/matroidbe/pgbrew/internal/pgcluster"
+	"github.com/matroidbe/pgbrew/internal/sharedlib"
+	"github.com/matroidbe/pgbrew/internal/tap"
 	"github.com/spf13/cobra"
 
 	// Register builders
 	_ "github.com/matroidbe/pgbrew/internal/builder"
 )
 
-var useSudo bool
+var (
+	useSudo             bool
+	installNoCache      bool
+	installBottleDir    string
+	installMakeArgs     []string
+	installCargoFeature []string
+	installEnv          []string
+	installCC           string
+	installResetOptions bool
+	installSmokeTest    bool
+	installForce        bool
+
+	// installRebuild builds even when the build cache has a matching build
+	// (set by 'pgx reinstall')
+	installRebuild bool
+)
 
 var installCmd = &cobra.Command{
 	Use:   "install <source>",
@@ -27,151 +52,795 @@ Supported extension types:
   - pgrx (Rust): Projects with Cargo.toml containing pgrx dependency
   - pgxs (C):    Projects with Makefile using PGXS and a .control file
 
+GitHub repositories are kept as local mirrors that are fetched incrementally.
+Builds are cached by commit, PostgreSQL version, build system and toolchain,
+so installing the same extension again is near-instant. Use --no-cache to
+force a fresh checkout and build.
+
+Build options (--make-arg, --cargo-feature, --env, --cc) are recorded with the
+installation. Installing the same extension again without any build options
+reuses the recorded ones; use --reset-options to build with the defaults.
+
+An extension pinned with 'pgx pin' is only installed at its pinned version,
+unless --force is given.
+
+With --smoke-test, the installed extension is loaded in a throwaway cluster
+(CREATE EXTENSION and a version check), which catches missing shared
+libraries that a successful build doesn't reveal.
+
+With --offline, pgx never touches the network: sources must already be in
+the local git mirror (see 'pgx fetch'), or a prebuilt build must exist in the
+build cache or in a bottle directory (--bottle-dir). A bottle directory is a
+copy of another host's build cache ("~/.cache/pgbrew/builds").
+
+A bare name is looked up in the aliases setting, then in the formulas of
+the configured taps (see 'pgx tap'). A formula supplies the source, default
+ref and build options.
+
 Examples:
   pgx install github.com/pgvector/pgvector
+  pgx install pg_search
+  pgx install pg_search@v0.15.1
   pgx install github.com/supabase/pg_graphql
   pgx install github.com/supabase/pg_graphql@v1.5.0
   pgx install github.com/user/repo/extensions/myext@main
   pgx install ./pg_hello
   pgx install /path/to/extension
-  pgx install --sudo github.com/pgvector/pgvector  # Install with sudo for system PostgreSQL`,
+  pgx install --sudo github.com/pgvector/pgvector  # Install with sudo for system PostgreSQL
+  pgx install --cargo-feature icu github.com/paradedb/paradedb/pg_search
+  pgx install --make-arg USE_PGXS=1 --env PATH=/opt/bin:/usr/bin --cc clang ./myext`,
 	Args: cobra.ExactArgs(1),
 	RunE: runInstall,
 }
 
 func init() {
 	installCmd.Flags().BoolVar(&useSudo, "sudo", false, "Use sudo for installation (needed for system PostgreSQL)")
+	installCmd.Flags().BoolVar(&installNoCache, "no-cache", false, "Do not use or populate the build cache and checkout cache")
+	installCmd.Flags().StringArrayVar(&installMakeArgs, "make-arg", nil, "Extra argument for make, e.g. VAR=value (repeatable)")
+	installCmd.Flags().StringArrayVar(&installCargoFeature, "cargo-feature", nil, "Extra cargo feature for pgrx builds (repeatable)")
+	installCmd.Flags().StringArrayVar(&installEnv, "env", nil, "Extra environment variable for the build, as KEY=VAL (repeatable)")
+	installCmd.Flags().StringVar(&installCC, "cc", "", "C compiler to build with")
+	installCmd.Flags().BoolVar(&installResetOptions, "reset-options", false, "Ignore build options recorded by a previous install")
+	installCmd.Flags().BoolVar(&installForce, "force", false, "Install even if the extension is pinned to another version")
+	installCmd.Flags().BoolVar(&installSmokeTest, "smoke-test", false, "Check that the extension loads, using CREATE EXTENSION in a throwaway cluster")
+	installCmd.Flags().StringVar(&installBottleDir, "bottle-dir", "", "Directory of prebuilt builds to install from (default from the bottle_dir setting)")
+}
+
+// sourceTree is an extension source checked out and ready to build.
+type sourceTree struct {
+	Source  string // Source as given by the user
+	Repo    string // GitHub repository (empty for local sources)
+	Subpath string // Extension directory inside the repository
+	Ref     string // Requested tag, branch, or commit
+	Commit  string // Commit SHA (empty for local sources)
+	Root    string // Root of the checkout (or the local directory)
+	Dir     string // Directory containing the extension
+	Name    string // Extension name, once detected
+
+	// Manifest is the extension's pgbrew.toml (nil if it has none), with
+	// the formula's settings applied
+	Manifest *manifest.Manifest
+
+	// Formula is the tap formula the source was resolved from (nil if none)
+	Formula *tap.Formula
+
+	// Bottle is a prebuilt build used when the source is unavailable offline
+	Bottle *cache.Build
+
+	// Log captures the output of every step (nil streams it)
+	Log *buildlog.Log
+
+	cleanup func()
+}
+
+// nameOr returns the extension name, or a guess from source if it is not
+// known yet.
+func (s *sourceTree) nameOr(source string) string {
+	if s != nil && s.Name != "" {
+		return s.Name
+	}
+	return logNameFor(source)
+}
+
+// Cleanup removes any temporary checkout.
+func (s *sourceTree) Cleanup() {
+	if s.cleanup != nil {
+		s.cleanup()
+	}
 }
 
-func runInstall(cmd *cobra.Command, args []string) error {
-	source := args[0]
+func runInstall(cmd *cobra.Command, args []string) (err error) {
+	cache.SetBottleDir(installBottleDir)
 
-	var extDir string
-	var cleanupDir string
+	for _, env := range installEnv {
+		if k, _, ok := strings.Cut(env, "="); !ok || k == "" {
+			return fmt.Errorf("invalid --env %q: expected KEY=VAL", env)
+		}
+	}
 
+	if installSmokeTest {
+		if err := pgcluster.CheckUser(); err != nil {
+			return fmt.Errorf("--smoke-test: %w", err)
+		}
+	}
+
+	change := beginChange("install", useSudo)
+	var src *sourceTree
+	defer func() {
+		source := args[0]
+		if src != nil {
+			source = src.Source
+		}
+		change.record(src.nameOr(args[0]), source, err)
+	}()
+
+	source, formula, err := resolveSource(args[0])
+	if err != nil {
+		return err
+	}
+	log, err := buildlog.Start(logNameFor(source))
+	if err != nil {
+		fmt.Printf("⚠ Could not create build log: %v\n", err)
+	}
+	defer log.Close()
+
+	src, err = prepareSource(source, log)
+	if err != nil {
+		return reportFailure(log, err)
+	}
+	defer src.Cleanup()
+	src.applyFormula(formula)
+
+	if err := installFromSource(src); err != nil {
+		return reportFailure(log, err)
+	}
+	return nil
+}
+
+// resolveSource expands aliases and tap formulas into a source to install
+// from. It also returns the formula the source came from, if any.
+func resolveSource(source string) (string, *tap.Formula, error) {
+	if isLocalPath(source) {
+		return source, nil, nil
+	}
+	source = resolveAlias(source)
+
+	name, ref, _ := strings.Cut(source, "@")
+	if isLocalPath(name) || strings.HasPrefix(name, "github.com/") {
+		return source, nil, nil
+	}
+	f, err := tap.Find(name)
+	if err != nil || f == nil {
+		return source, nil, err
+	}
+	resolved := f.SourceAt(ref)
+	fmt.Printf("Using formula %s/%s: %s\n", f.Tap, f.Name, resolved)
+	return resolved, f, nil
+}
+
+// resolveAlias expands a source alias from the aliases settings. A ref
+// given with the alias (name@ref) replaces the alias's own ref.
+func resolveAlias(source string) string {
+	if isLocalPath(source) {
+		return source
+	}
+	name, ref, hasRef := strings.Cut(source, "@")
+	target, ok := config.Section("aliases")[name]
+	if !ok {
+		return source
+	}
+	if hasRef {
+		if idx := strings.LastIndex(target, "@"); idx != -1 {
+			target = target[:idx]
+		}
+		target += "@" + ref
+	}
+	fmt.Printf("Using alias %s: %s\n", name, target)
+	return target
+}
+
+// logNameFor guesses the extension name from a source, to name its build log
+// until the real name is known.
+func logNameFor(source string) string {
+	if isLocalPath(source) {
+		if abs, err := filepath.Abs(source); err == nil {
+			return filepath.Base(abs)
+		}
+	}
+	if idx := strings.LastIndex(source, "@"); idx != -1 {
+		source = source[:idx]
+	}
+	return path.Base(strings.TrimSuffix(source, "/"))
+}
+
+// reportFailure prints the relevant part of the build log for a failed
+// install and returns err.
+func reportFailure(log *buildlog.Log, err error) error {
+	if log == nil || buildlog.Verbose() {
+		return err
+	}
+	if summary := log.ErrorSummary(); summary != "" {
+		fmt.Println()
+		fmt.Println("Last output:")
+		for _, line := range strings.Split(summary, "\n") {
+			fmt.Printf("  %s\n", line)
+		}
+	}
+	fmt.Printf("\nFull build log: %s\n\n", log.Path())
+	return err
+}
+
+// prepareSource resolves a local path or GitHub URL to a directory to build from.
+// GitHub sources are fetched into a persistent mirror and checked out as worktrees.
+func prepareSource(source string, log *buildlog.Log) (*sourceTree, error) {
 	// Check if source is a local path
 	if isLocalPath(source) {
 		absPath, err := filepath.Abs(source)
 		if err != nil {
-			return fmt.Errorf("invalid path: %w", err)
+			return nil, fmt.Errorf("invalid path: %w", err)
 		}
 
 		// Verify directory exists
 		if _, err := os.Stat(absPath); os.IsNotExist(err) {
-			return fmt.Errorf("directory not found: %s", absPath)
+			return nil, fmt.Errorf("directory not found: %s", absPath)
 		}
 
-		extDir = absPath
 		fmt.Printf("Installing from %s...\n", absPath)
-	} else {
-		// Parse GitHub URL
-		repo, subpath, version, err := github.ParseURL(source)
-		if err != nil {
-			return fmt.Errorf("invalid source: %w", err)
+		src := &sourceTree{Source: source, Root: absPath, Dir: absPath, Log: log}
+		if err := loadManifest(src); err != nil {
+			return nil, err
 		}
+		return src, nil
+	}
+
+	// Parse GitHub URL
+	repo, subpath, version, err := github.ParseURL(source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source: %w", err)
+	}
 
-		fmt.Printf("Installing from %s...\n", source)
+	fmt.Printf("Installing from %s...\n", source)
+	src := &sourceTree{Source: source, Repo: repo, Subpath: subpath, Ref: version, Log: log}
 
-		// Clone repository to temp directory
-		tmpDir, err := os.MkdirTemp("", "pgbrew-*")
+	// Fetch into the persistent mirror, then resolve the ref locally
+	mirror, err := github.UpdateMirror(repo, log)
+	if err == nil {
+		src.Commit, err = github.ResolveRef(mirror, version)
+	}
+	if err != nil {
+		if offline.Enabled() {
+			// Without the source, a prebuilt bottle is the only option
+			if bottle := findBottle(repo, subpath, version); bottle != nil {
+				fmt.Printf("Source unavailable offline, using prebuilt build %s\n", bottle.Key)
+				src.Commit = bottle.Commit
+				src.Bottle = bottle
+				return src, nil
+			}
+			return nil, fmt.Errorf("%w\n  No prebuilt build of %s for pg%s found in the build cache or bottle directory either", err, source, getPgVersion())
+		}
+		return nil, fmt.Errorf("failed to fetch %s: %w", source, err)
+	}
+
+	worktree, err := checkoutWorktree(src, mirror)
+	if err != nil {
+		return nil, err
+	}
+
+	src.Root = worktree
+	src.Dir = filepath.Join(worktree, subpath)
+	if err := loadManifest(src); err != nil {
+		return nil, err
+	}
+	return src, nil
+}
+
+// loadManifest finds the extension's pgbrew.toml. A manifest at the root of
+// the source can point to the extension directory with subpath.
+func loadManifest(src *sourceTree) error {
+	if src.Subpath == "" {
+		root, err := manifest.Load(src.Root)
 		if err != nil {
-			return fmt.Errorf("failed to create temp directory: %w", err)
+			return err
+		}
+		if root != nil && root.Subpath != "" {
+			src.Subpath = root.Subpath
+			src.Dir = filepath.Join(src.Root, root.Subpath)
+			if _, err := os.Stat(src.Dir); err != nil {
+				return fmt.Errorf("subpath %q from %s not found", root.Subpath, manifest.FileName)
+			}
 		}
-		cleanupDir = tmpDir
+	}
+
+	m, err := manifest.Find(src.Root, src.Subpath)
+	if err != nil {
+		return err
+	}
+	if m != nil {
+		fmt.Printf("Using %s\n", filepath.Join(m.Dir, manifest.FileName))
+	}
+	src.Manifest = m
+	return nil
+}
+
+// applyFormula makes the settings of the formula the source was resolved
+// from take precedence over the extension's own manifest.
+func (s *sourceTree) applyFormula(f *tap.Formula) {
+	if f == nil {
+		return
+	}
+	s.Formula = f
+	s.Manifest = f.Apply(s.Manifest)
+}
 
-		if version != "" {
-			fmt.Printf("Cloning %s@%s...\n", repo, version)
-		} else {
-			fmt.Printf("Cloning %s...\n", repo)
+// checkoutWorktree returns a worktree of src.Commit. Worktrees are kept in the
+// cache keyed by commit, unless --no-cache is set.
+func checkoutWorktree(src *sourceTree, mirror string) (string, error) {
+	if !installNoCache {
+		dir, err := cache.SourceDir(src.Repo, src.Commit)
+		if err != nil {
+			return "", err
 		}
-		if err := github.Clone(repo, tmpDir, version); err != nil {
-			return fmt.Errorf("failed to clone repository: %w", err)
+		if _, err := os.Stat(dir); err == nil {
+			fmt.Printf("Using cached checkout of %s@%s\n", src.Repo, shortSHA(src.Commit))
+			if err := github.ExpandSparsePath(dir, src.Subpath, src.Log); err != nil {
+				return "", err
+			}
+			cache.TouchSource(dir) // Keep it from being pruned
+			return dir, nil
 		}
 
-		// Determine extension directory
-		extDir = tmpDir
-		if subpath != "" {
-			extDir = filepath.Join(tmpDir, subpath)
+		fmt.Printf("Checking out %s@%s...\n", src.Repo, shortSHA(src.Commit))
+		if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+			return "", err
+		}
+		if err := github.AddWorktree(mirror, src.Commit, dir, src.Subpath, src.Log); err != nil {
+			github.RemoveWorktree(mirror, dir)
+			return "", fmt.Errorf("failed to check out repository: %w", err)
 		}
+		cache.TouchSource(dir)
+		return dir, nil
 	}
 
-	// Cleanup temp directory if created
-	if cleanupDir != "" {
-		defer os.RemoveAll(cleanupDir)
+	tmpDir, err := os.MkdirTemp("", "pgbrew-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	// git worktree add wants to create the directory itself
+	os.Remove(tmpDir)
+
+	fmt.Printf("Checking out %s@%s...\n", src.Repo, shortSHA(src.Commit))
+	src.cleanup = func() { github.RemoveWorktree(mirror, tmpDir) }
+	if err := github.AddWorktree(mirror, src.Commit, tmpDir, src.Subpath, src.Log); err != nil {
+		return "", fmt.Errorf("failed to check out repository: %w", err)
 	}
+	return tmpDir, nil
+}
 
-	// Detect the appropriate builder for this project
-	b, err := builder.DetectBuilder(extDir)
+// installFromSource builds (or reuses a cached build of) the extension in src
+// and installs it into the selected PostgreSQL.
+func installFromSource(src *sourceTree) error {
+	if src.Bottle != nil {
+		return installBottle(src)
+	}
+
+	build, err := buildSource(src)
 	if err != nil {
 		return err
 	}
+	defer build.Cleanup()
+	return deploySource(src, build)
+}
 
-	fmt.Printf("Detected %s project\n", b.Name())
+// stagedBuild is a staged install tree of an extension, built or taken from
+// the build cache, with the cellar entry it is installed under.
+type stagedBuild struct {
+	Entry   cellar.Entry
+	TreeDir string
+	cleanup func()
+}
 
-	// Get extension name
-	extName, err := b.GetExtensionName(extDir)
+// Cleanup removes the tree if it was built for this install only.
+func (s *stagedBuild) Cleanup() {
+	if s.cleanup != nil {
+		s.cleanup()
+	}
+}
+
+// buildSource builds the extension in src into a staging tree, or finds a
+// cached build of it, without installing anything.
+func buildSource(src *sourceTree) (*stagedBuild, error) {
+	extDir := src.Dir
+
+	// Detect the appropriate builder for this project, unless the manifest names one
+	b, err := builder.DetectBuilderFor(extDir, src.Manifest)
 	if err != nil {
-		return fmt.Errorf("failed to get extension name: %w", err)
+		return nil, err
 	}
 
-	fmt.Printf("Building %s...\n", extName)
+	fmt.Printf("Detected %s project\n", b.Name())
 
-	// Build and install
-	pgConfig := getPgConfigPath()
-	opts := builder.InstallOptions{
-		PgConfig: pgConfig,
-		UseSudo:  useSudo,
+	// Get extension name
+	extName, err := b.GetExtensionName(extDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get extension name: %w", err)
 	}
-	if err := b.Install(extDir, opts); err != nil {
-		return fmt.Errorf("failed to install extension: %w", err)
+	src.Name = extName
+	if err := src.Log.SetName(extName); err != nil {
+		fmt.Printf("⚠ Could not move build log: %v\n", err)
 	}
 
-	// Set sudo mode for cellar operations
-	cellar.SetUseSudo(useSudo)
-
 	// Get version
 	version, _ := b.GetVersion(extDir)
 	if version == "" {
 		version = "unknown"
 	}
+	if err := checkPin(extName, version); err != nil {
+		return nil, err
+	}
+
+	buildOpts := buildOptionsFor(extName, src.Formula)
+	opts := builder.InstallOptions{
+		PgConfig:      getPgConfigPath(),
+		UseSudo:       useSudo,
+		MakeArgs:      buildOpts.MakeArgs,
+		CargoFeatures: buildOpts.CargoFeatures,
+		Env:           buildOpts.Env,
+		CC:            buildOpts.CC,
+		Manifest:      src.Manifest,
+		Log:           src.Log,
+	}
 
 	// Get PostgreSQL version
 	pgVersion := getPgVersion()
+	if err := checkManifest(src.Manifest, pgVersion); err != nil {
+		return nil, err
+	}
+	toolchain := b.Toolchain(extDir, opts)
+
+	// Hooks run on every install, whether the build comes from the cache or not
+	if src.Manifest != nil {
+		if err := manifest.RunHooks("pre_install", src.Manifest.Hooks.PreInstall, extDir, hookEnv(), src.Log); err != nil {
+			return nil, err
+		}
+	}
+
+	pkgLibDir, shareDir := pgInstallDirs()
+	key := cache.Key{
+		Commit:      src.Commit,
+		Subpath:     src.Subpath,
+		PgVersion:   pgVersion,
+		BuildSystem: b.Name(),
+		Toolchain:   toolchain,
+		Options:     buildOpts.String(),
+		Manifest:    src.Manifest.BuildSettings(),
+		PkgLibDir:   pkgLibDir,
+		ShareDir:    shareDir,
+	}
+	useCache := src.Commit != "" && !installNoCache
+
+	staged := &stagedBuild{}
+	var treeDir string
+	if useCache && !installRebuild {
+		if build, err := cache.Lookup(key); err == nil && build != nil {
+			fmt.Printf("Using cached build %s (built %s)\n", build.Key, build.CreatedAt.Format("2006-01-02 15:04"))
+			treeDir = build.TreeDir()
+		}
+	}
+
+	if treeDir == "" {
+		fmt.Printf("Building %s...\n", extName)
+
+		// Build into a staging directory; files are copied into place afterwards
+		stageDir, err := os.MkdirTemp("", "pgbrew-stage-*")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create staging directory: %w", err)
+		}
+		staged.cleanup = func() { os.RemoveAll(stageDir) }
+
+		opts.DestDir = stageDir
+		if err := b.Install(extDir, opts); err != nil {
+			staged.Cleanup()
+			return nil, fmt.Errorf("failed to install extension: %w", err)
+		}
+		treeDir = stageDir
+
+		if useCache {
+			build := cache.Build{Name: extName, Version: version, Source: src.Source, Repo: src.Repo, Ref: src.Ref}
+			if _, err := cache.Store(key, build, stageDir); err != nil {
+				fmt.Printf("⚠ Could not cache build: %v\n", err)
+			}
+		}
+	}
 
-	// Record installation
 	entry := cellar.Entry{
 		Name:        extName,
 		Version:     version,
-		Source:      source,
+		Source:      src.Source,
 		PgVersion:   pgVersion,
 		BuildSystem: b.Name(),
+		Commit:      src.Commit,
+		Toolchain:   toolchain,
+		Compiler:    b.Compiler(opts),
+	}
+	if !buildOpts.IsEmpty() {
+		entry.Options = &buildOpts
+	}
+	if src.Formula != nil {
+		entry.Formula = src.Formula.Tap + "/" + src.Formula.Name
+	}
+	entry.SharedPreload = builder.NeedsSharedPreload(b, extDir, src.Manifest, treeModules(treeDir))
+	staged.Entry = entry
+	staged.TreeDir = treeDir
+	return staged, nil
+}
+
+// deploySource installs a staged build of the extension in src into the
+// selected PostgreSQL.
+func deploySource(src *sourceTree, build *stagedBuild) error {
+	if err := deployBuild(build.Entry, build.TreeDir); err != nil {
+		return err
+	}
+
+	if src.Manifest != nil {
+		if err := manifest.RunHooks("post_install", src.Manifest.Hooks.PostInstall, src.Dir, hookEnv(), src.Log); err != nil {
+			return err
+		}
+	}
+
+	if installSmokeTest {
+		if err := smokeTest(build.Entry, src.Log); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkManifest checks the requirements an extension declares in its
+// manifest against this host before building.
+func checkManifest(m *manifest.Manifest, pgVersion string) error {
+	if m == nil {
+		return nil
+	}
+
+	if major, err := strconv.Atoi(pgVersion); err == nil && !m.SupportsPostgres(major) {
+		return fmt.Errorf("extension supports %s, but pg_config is PostgreSQL %s", m.PostgresRange(), pgVersion)
+	}
+
+	family := distroFamily()
+	var missing []string
+	for _, pkg := range m.SystemDeps[family] {
+		if installed, checked := sharedlib.PackageInstalled(pkg, family); checked && !installed {
+			missing = append(missing, pkg)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing system packages required by %s: %s\n  Install: %s",
+			manifest.FileName, strings.Join(missing, ", "), getInstallHint(strings.Join(missing, " ")))
+	}
+	return nil
+}
+
+// hookEnv returns the environment for manifest hooks.
+func hookEnv() []string {
+	return []string{"PG_CONFIG=" + getPgConfigPath()}
+}
+
+// buildOptionsFor returns the build options for an extension: those given on
+// the command line, or else the ones recorded by its previous installation,
+// or else the defaults of its formula.
+func buildOptionsFor(extName string, formula *tap.Formula) cellar.BuildOptions {
+	opts := cellar.BuildOptions{
+		MakeArgs:      installMakeArgs,
+		CargoFeatures: installCargoFeature,
+		Env:           installEnv,
+		CC:            installCC,
+	}
+	if !opts.IsEmpty() {
+		return opts
+	}
+
+	if !installResetOptions {
+		if prev, err := cellar.Get(extName); err == nil && prev.Options != nil && !prev.Options.IsEmpty() {
+			fmt.Printf("Using recorded build options: %s\n", prev.Options)
+			return *prev.Options
+		}
+	}
+
+	if formula != nil {
+		defaults := cellar.BuildOptions{
+			MakeArgs:      formula.Options.MakeArgs,
+			CargoFeatures: formula.Options.CargoFeatures,
+			Env:           formula.Options.Env,
+			CC:            formula.Options.CC,
+		}
+		if !defaults.IsEmpty() {
+			fmt.Printf("Using build options of formula %s: %s\n", formula.Name, defaults)
+			return defaults
+		}
+	}
+	return opts
+}
+
+// installBottle installs a prebuilt build without access to its source.
+func installBottle(src *sourceTree) error {
+	b := src.Bottle
+	src.Name = b.Name
+	src.Log.SetName(b.Name)
+	if err := checkPin(b.Name, b.Version); err != nil {
+		return err
+	}
+	if current := builderToolchain(b.BuildSystem); current != "" && current != b.Toolchain {
+		fmt.Printf("⚠ Prebuilt with %s (this host has %s)\n", b.Toolchain, current)
+	}
+
+	entry := cellar.Entry{
+		Name:        b.Name,
+		Version:     b.Version,
+		Source:      src.Source,
+		PgVersion:   b.PgVersion,
+		BuildSystem: b.BuildSystem,
+		Commit:      b.Commit,
+		Toolchain:   b.Toolchain,
+	}
+	if src.Formula != nil {
+		entry.Formula = src.Formula.Tap + "/" + src.Formula.Name
+	}
+	entry.SharedPreload, _ = builder.ModulesNeedSharedPreload(treeModules(b.TreeDir()))
+	if err := deployBuild(entry, b.TreeDir()); err != nil {
+		return err
+	}
+	if installSmokeTest {
+		return smokeTest(entry, src.Log)
+	}
+	return nil
+}
+
+// findBottle returns a prebuilt build of repo/subpath for the selected
+// PostgreSQL, if the build cache or bottle directory has one.
+func findBottle(repo, subpath, ref string) *cache.Build {
+	pkgLibDir, shareDir := pgInstallDirs()
+	bottle, _ := cache.FindBottle(repo, subpath, ref, getPgVersion(), pkgLibDir, shareDir)
+	return bottle
+}
+
+// pgInstallDirs returns the library and share directories of the selected
+// PostgreSQL, which staged trees are laid out for.
+func pgInstallDirs() (string, string) {
+	pgConfigPath := getPgConfigPath()
+	return strings.TrimSpace(getCommandOutput(pgConfigPath, "--pkglibdir")),
+		strings.TrimSpace(getCommandOutput(pgConfigPath, "--sharedir"))
+}
+
+// builderToolchain returns the local toolchain description for a build system,
+// as far as it can be determined without a source tree.
+func builderToolchain(buildSystem string) string {
+	if buildSystem != "pgxs" {
+		return ""
+	}
+	return (&builder.PgxsBuilder{}).Toolchain("", builder.InstallOptions{})
+}
+
+// deployBuild copies a built tree into the PostgreSQL installation, records
+// the installation in the cellar and keeps the tree in the store.
+func deployBuild(entry cellar.Entry, treeDir string) error {
+	// Save the files about to be replaced for 'pgx rollback'
+	if keep := config.Int("rollback_generations"); keep > 0 {
+		if prev, err := cellar.Get(entry.Name); err == nil && len(prev.Files) > 0 {
+			if _, err := cellar.Snapshot(*prev, keep); err != nil {
+				fmt.Printf("⚠ Could not save %s %s for rollback: %v\n", prev.Name, prev.Version, err)
+			}
+		}
+	}
+
+	// Copy the built files into the PostgreSQL installation
+	fmt.Println("Installing files...")
+	entry, err := linkTree(entry, treeDir)
+	if err != nil {
+		return err
 	}
-	if err := cellar.Add(entry); err != nil {
-		return fmt.Errorf("failed to record installation: %w", err)
+
+	// Keep this version so it can be linked again later
+	if _, err := cellar.SaveKeg(entry, treeDir); err != nil {
+		fmt.Printf("⚠ Could not keep %s %s in the store: %v\n", entry.Name, entry.Version, err)
 	}
 
-	fmt.Printf("\n✓ Successfully installed %s %s\n", extName, version)
-	fmt.Printf("  Run: CREATE EXTENSION %s;\n", extName)
+	fmt.Printf("\n✓ Successfully installed %s %s\n", entry.Name, entry.Version)
+	fmt.Printf("  Run: CREATE EXTENSION %s;\n", entry.Name)
 
-	// Check if extension needs shared_preload_libraries
-	if b.NeedsSharedPreload(extDir) {
-		pgMajor := getPgVersion()
-		pgMajorInt := 0
-		fmt.Sscanf(pgMajor, "%d", &pgMajorInt)
+	if entry.SharedPreload {
+		fmt.Println()
+		fmt.Println("⚠ This extension must be loaded at server start.")
+		fmt.Println("  Add it to shared_preload_libraries in postgresql.conf:")
+		fmt.Printf("    shared_preload_libraries = '%s'\n", entry.Name)
+		fmt.Println("  Then restart PostgreSQL.")
+	}
 
-		// Most background worker extensions need shared_preload_libraries on PG < 17
-		if pgMajorInt > 0 && pgMajorInt < 17 {
+	// Without clang and llvm-lto the build skips the JIT bitcode, which
+	// PostgreSQL then can't inline; the build log says so, but a cached or
+	// prebuilt tree would not
+	if entry.BuildSystem == "pgxs" {
+		if llvm := builder.DetectLLVM(getPgConfigPath()); llvm.MissingBitcode(entry.Files) {
 			fmt.Println()
-			fmt.Println("⚠ This extension uses background workers.")
-			fmt.Println("  You may need to add it to shared_preload_libraries in postgresql.conf:")
-			fmt.Printf("    shared_preload_libraries = '%s'\n", extName)
-			fmt.Println("  Then restart PostgreSQL.")
+			fmt.Printf("⚠ JIT bitcode was not installed for %s (PostgreSQL was built with LLVM)\n", entry.Name)
+			if len(llvm.Missing) > 0 {
+				fmt.Printf("  %s not found; install: %s\n", strings.Join(llvm.Missing, " and "), getInstallHint("clang llvm"))
+			}
+			fmt.Println("  Then reinstall it with: pgx reinstall " + entry.Name)
 		}
 	}
 
+	// A module that links against missing libraries installs fine but fails
+	// at CREATE EXTENSION, so warn now
+	if missing := missingLibraries(entry); len(missing) > 0 {
+		fmt.Println()
+		fmt.Println("⚠ Some system libraries this extension needs are not installed:")
+		printMissingLibraries(missing, "  ")
+	}
 	return nil
 }
 
+// smokeTest loads an installed extension in a throwaway cluster, so missing
+// shared libraries and load-time errors show up now rather than at the
+// first CREATE EXTENSION in production.
+func smokeTest(entry cellar.Entry, log *buildlog.Log) error {
+	fmt.Println()
+	opts := pgcluster.Options{Log: log}
+	if entry.SharedPreload {
+		opts.Settings = map[string]string{"shared_preload_libraries": "'" + entry.Name + "'"}
+	}
+	cluster, err := pgcluster.Start(getPgConfigPath(), opts)
+	if err != nil {
+		return fmt.Errorf("smoke test: %w", err)
+	}
+	defer cluster.Stop()
+
+	fail := func(err error) error {
+		printServerLog(cluster)
+		return fmt.Errorf("smoke test failed: %w\n  %s %s is installed, but does not load", err, entry.Name, entry.Version)
+	}
+
+	create := cluster.PsqlCommand("postgres", fmt.Sprintf("CREATE EXTENSION \"%s\" CASCADE", entry.Name))
+	if err := log.Run("Running CREATE EXTENSION "+entry.Name, create); err != nil {
+		return fail(err)
+	}
+
+	extVersion, err := cluster.Psql("postgres", fmt.Sprintf("SELECT extversion FROM pg_extension WHERE extname = '%s'", entry.Name))
+	if err != nil {
+		return fail(err)
+	}
+	if entry.Version != "unknown" && extVersion != entry.Version {
+		fmt.Printf("⚠ CREATE EXTENSION created version %s, but %s was installed (check default_version in the control file)\n", extVersion, entry.Version)
+	}
+
+	fmt.Printf("✓ Smoke test passed: %s %s loads\n", entry.Name, extVersion)
+	return nil
+}
+
+// treeModules returns the shared modules in a built install tree.
+func treeModules(treeDir string) []string {
+	files, err := builder.StagedFiles(treeDir)
+	if err != nil {
+		return nil
+	}
+	suffix := builder.ModuleSuffix(getPgConfigPath())
+	var modules []string
+	for _, f := range files {
+		if builder.IsModule(f, suffix) {
+			modules = append(modules, filepath.Join(treeDir, f))
+		}
+	}
+	return modules
+}
+
+// shortSHA abbreviates a commit SHA for display.
+func shortSHA(sha string) string {
+	if len(sha) > 12 {
+		return sha[:12]
+	}
+	return sha
+}
+
 func getPgVersion() string {
 	cmd := exec.Command(getPgConfigPath(), "--version")
 	output, err := cmd.Output()
@@ -0,0 +1,216 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/matroidbe/pgbrew/internal/builder"
+	"github.com/matroidbe/pgbrew/internal/cellar"
+	"github.com/spf13/cobra"
+)
+
+var linkCmd = &cobra.Command{
+	Use:   "link <extension>[@version]",
+	Short: "Install a stored version of an extension into PostgreSQL",
+	Long: `Every installed version of an extension is kept in the store
+(~/.local/share/pgbrew/Cellar), so that it can be put back without building.
+
+link places the files of a stored version into pkglibdir and sharedir. It
+refuses if another version is linked; use 'pgx switch' to replace it. unlink
+keeps the extension's pin, so link refuses another version unless --force
+is given.
+
+Examples:
+  pgx link vector@0.7.4
+  pgx unlink vector
+  pgx switch vector 0.8.0`,
+	Args: cobra.ExactArgs(1),
+	RunE: runLink,
+}
+
+var unlinkCmd = &cobra.Command{
+	Use:   "unlink <extension>",
+	Short: "Remove an extension's files from PostgreSQL, keeping it in the store",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runUnlink,
+}
+
+var switchCmd = &cobra.Command{
+	Use:   "switch <extension> <version>",
+	Short: "Replace the linked version of an extension with a stored one",
+	Long: `Replace the linked version of an extension with another stored version.
+
+Databases that already created the extension keep their catalog version;
+run ALTER EXTENSION ... UPDATE where needed after switching.`,
+	Args: cobra.ExactArgs(2),
+	RunE: runSwitch,
+}
+
+func init() {
+	for _, c := range []*cobra.Command{linkCmd, unlinkCmd, switchCmd} {
+		c.Flags().BoolVar(&useSudo, "sudo", false, "Use sudo to change files (needed for system PostgreSQL)")
+	}
+	linkCmd.Flags().BoolVar(&installForce, "force", false, "Link even if the extension is pinned to another version")
+	unlinkCmd.Flags().BoolVar(&installForce, "force", false, "Unlink even if databases use the extension, or that can't be checked")
+	switchCmd.Flags().BoolVar(&installForce, "force", false, "Switch even if the extension is pinned to another version")
+}
+
+func runLink(cmd *cobra.Command, args []string) (err error) {
+	name, version, _ := strings.Cut(args[0], "@")
+	change := beginChange("link", useSudo)
+	defer func() { change.record(name, "", err) }()
+
+	k, err := findKeg(name, version)
+	if err != nil {
+		return err
+	}
+
+	if linked, err := cellar.Get(name); err == nil {
+		if linked.Version == k.Version {
+			fmt.Printf("%s %s is already linked\n", name, k.Version)
+			return nil
+		}
+		return fmt.Errorf("%s %s is linked; use 'pgx switch %s %s' to replace it", name, linked.Version, name, k.Version)
+	}
+	if err := checkPin(name, k.Version); err != nil {
+		return err
+	}
+
+	if err := linkKeg(k); err != nil {
+		return err
+	}
+	fmt.Printf("✓ Linked %s %s\n", name, k.Version)
+	return nil
+}
+
+func runUnlink(cmd *cobra.Command, args []string) (err error) {
+	name := args[0]
+	entry, err := cellar.Get(name)
+	if err != nil {
+		return fmt.Errorf("%s is not linked", name)
+	}
+	change := beginChange("unlink", useSudo)
+	defer func() { change.record(name, "", err) }()
+
+	if dbs, err := findDatabasesWithExtension(name); err != nil && !installForce {
+		return fmt.Errorf("could not check which databases use %s: %w; use --force to unlink anyway", name, err)
+	} else if len(dbs) > 0 && !installForce {
+		return fmt.Errorf("%s is used in database(s) %s; use --force to unlink anyway", name, strings.Join(dbs, ", "))
+	}
+
+	cellar.SetUseSudo(useSudo)
+	if err := builder.RemoveFiles(entry.Files, useSudo); err != nil {
+		return err
+	}
+	if err := cellar.Unlink(name); err != nil {
+		return fmt.Errorf("failed to update cellar: %w", err)
+	}
+	fmt.Printf("✓ Unlinked %s %s (%d files removed, kept in the store)\n", name, entry.Version, len(entry.Files))
+	return nil
+}
+
+func runSwitch(cmd *cobra.Command, args []string) (err error) {
+	name, version := args[0], args[1]
+	change := beginChange("switch", useSudo)
+	defer func() { change.record(name, "", err) }()
+
+	k, err := findKeg(name, version)
+	if err != nil {
+		return err
+	}
+
+	previous := ""
+	if linked, err := cellar.Get(name); err == nil {
+		if linked.Version == k.Version {
+			fmt.Printf("%s %s is already linked\n", name, k.Version)
+			return nil
+		}
+		previous = linked.Version
+	}
+	if err := checkPin(name, k.Version); err != nil {
+		return err
+	}
+
+	if err := linkKeg(k); err != nil {
+		return err
+	}
+	if previous == "" {
+		fmt.Printf("✓ Linked %s %s\n", name, k.Version)
+		return nil
+	}
+	fmt.Printf("✓ Switched %s from %s to %s\n", name, previous, k.Version)
+
+	if dbs, _ := findDatabasesWithExtension(name); len(dbs) > 0 {
+		fmt.Printf("\n⚠ %s is used in database(s) %s, which keep version %s in their catalog.\n", name, strings.Join(dbs, ", "), previous)
+		fmt.Printf("  Run in each: ALTER EXTENSION %s UPDATE TO '%s';\n", name, k.Version)
+	}
+	return nil
+}
+
+// findKeg returns the stored version of an extension. Without a version,
+// the only stored version is used.
+func findKeg(name string, version string) (*cellar.Keg, error) {
+	kegs, err := cellar.Kegs(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(kegs) == 0 {
+		return nil, fmt.Errorf("no versions of %s are in the store (install it first)", name)
+	}
+
+	var versions []string
+	for i := range kegs {
+		if kegs[i].Version == version || (version == "" && len(kegs) == 1) {
+			return &kegs[i], nil
+		}
+		versions = append(versions, kegs[i].Version)
+	}
+	if version == "" {
+		return nil, fmt.Errorf("several versions of %s are stored (%s); give one as %s@<version>", name, strings.Join(versions, ", "), name)
+	}
+	return nil, fmt.Errorf("%s %s is not in the store (stored: %s)", name, version, strings.Join(versions, ", "))
+}
+
+// linkKeg makes a stored version the linked version of its extension.
+func linkKeg(k *cellar.Keg) error {
+	entry := k.Entry
+	entry.Files = nil
+	_, err := linkTree(entry, k.TreeDir())
+	return err
+}
+
+// linkTree installs the files of a staged tree as the linked version of an
+// extension and records it in the cellar. Files of the previously linked
+// version that the new one doesn't have are removed.
+func linkTree(entry cellar.Entry, treeDir string) (cellar.Entry, error) {
+	previous, _ := cellar.Get(entry.Name)
+
+	files, err := builder.Deploy(treeDir, useSudo)
+	if err != nil {
+		return entry, fmt.Errorf("failed to install extension: %w", err)
+	}
+	entry.Files = files
+
+	if previous != nil {
+		current := make(map[string]bool, len(files))
+		for _, f := range files {
+			current[f] = true
+		}
+		var stale []string
+		for _, f := range previous.Files {
+			if !current[f] {
+				stale = append(stale, f)
+			}
+		}
+		if err := builder.RemoveFiles(stale, useSudo); err != nil {
+			fmt.Printf("⚠ Could not remove files of %s %s: %v\n", previous.Name, previous.Version, err)
+		}
+	}
+
+	// Set sudo mode for cellar operations
+	cellar.SetUseSudo(useSudo)
+	if err := cellar.Add(entry); err != nil {
+		return entry, fmt.Errorf("failed to record installation: %w", err)
+	}
+	return entry, nil
+}
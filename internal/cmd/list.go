@@ -1,13 +1,13 @@
 package cmd
 
 import (
-	"bufio"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 
 	"github.com/matroidbe/pgbrew/internal/cellar"
+	"github.com/matroidbe/pgbrew/internal/control"
 	"github.com/spf13/cobra"
 )
 
@@ -41,23 +41,55 @@ func runList(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to list extensions: %w", err)
 	}
 
+	if jsonOutput() {
+		return printJSON(entries)
+	}
+
 	if len(entries) == 0 {
 		fmt.Printf("No extensions installed via pgbrew in %s\n", extDir)
 		fmt.Println("Use --all to see all PostgreSQL extensions.")
+		printUnlinked(entries)
 		return nil
 	}
 
 	fmt.Printf("Extensions installed via pgbrew (%s):\n\n", extDir)
 	for _, e := range entries {
-		fmt.Printf("  %s %s\n", e.Name, e.Version)
+		if e.Pinned != "" {
+			fmt.Printf("  %s %s (pinned to %s)\n", e.Name, e.Version, e.Pinned)
+		} else {
+			fmt.Printf("  %s %s\n", e.Name, e.Version)
+		}
 		if e.Source != "" {
 			fmt.Printf("    Source: %s\n", e.Source)
 		}
 	}
 
+	printUnlinked(entries)
 	return nil
 }
 
+// printUnlinked lists extensions that are in the store but not linked.
+func printUnlinked(entries []cellar.Entry) {
+	linked := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		linked[e.Name] = true
+	}
+	kegs, err := cellar.Kegs("")
+	if err != nil {
+		return
+	}
+
+	var unlinked []string
+	for _, k := range kegs {
+		if !linked[k.Name] {
+			unlinked = append(unlinked, k.Name+"@"+k.Version)
+		}
+	}
+	if len(unlinked) > 0 {
+		fmt.Printf("\nIn the store but not linked (see 'pgx link'):\n  %s\n", strings.Join(unlinked, "\n  "))
+	}
+}
+
 // extensionInfo holds parsed information from a .control file
 type extensionInfo struct {
 	Name    string
@@ -146,40 +178,9 @@ func parseControlFile(path string) extensionInfo {
 	ext := extensionInfo{
 		Name: strings.TrimSuffix(filepath.Base(path), ".control"),
 	}
-
-	file, err := os.Open(path)
-	if err != nil {
-		return ext
+	if c, err := control.Parse(path); err == nil {
+		ext.Version = c.DefaultVersion
+		ext.Comment = c.Comment
 	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-
-		// Skip comments and empty lines
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-
-		// Parse key = value
-		parts := strings.SplitN(line, "=", 2)
-		if len(parts) != 2 {
-			continue
-		}
-
-		key := strings.TrimSpace(parts[0])
-		value := strings.TrimSpace(parts[1])
-		// Remove quotes
-		value = strings.Trim(value, "'\"")
-
-		switch key {
-		case "default_version":
-			ext.Version = value
-		case "comment":
-			ext.Comment = value
-		}
-	}
-
 	return ext
 }
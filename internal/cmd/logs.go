@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/matroidbe/pgbrew/internal/buildlog"
+	"github.com/spf13/cobra"
+)
+
+var (
+	logsList    bool
+	logsSummary bool
+)
+
+var logsCmd = &cobra.Command{
+	Use:   "logs <extension> [log]",
+	Short: "Show build logs of an extension",
+	Long: `Show build logs of an extension.
+
+Every install writes the full output of each build step to
+~/.local/state/pgbrew/logs/<extension>/<timestamp>-<id>.log. By default the
+most recent log is printed; name a specific log (as shown by --list), or a
+unique prefix of its name such as its date and time, to print it.
+
+Examples:
+  pgx logs pg_search
+  pgx logs --list pg_search
+  pgx logs --summary pg_search
+  pgx logs pg_search 20250101-120000`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: runLogs,
+}
+
+func init() {
+	logsCmd.Flags().BoolVar(&logsList, "list", false, "List available logs")
+	logsCmd.Flags().BoolVar(&logsSummary, "summary", false, "Show only the last error block")
+}
+
+func runLogs(cmd *cobra.Command, args []string) error {
+	name := args[0]
+
+	logs, err := buildlog.List(name)
+	if err != nil {
+		return fmt.Errorf("failed to list logs: %w", err)
+	}
+	if len(logs) == 0 {
+		dir, _ := buildlog.Dir()
+		return fmt.Errorf("no build logs for %s in %s", name, dir)
+	}
+
+	if logsList {
+		fmt.Printf("Build logs for %s:\n\n", name)
+		for _, l := range logs {
+			size := int64(0)
+			if info, err := os.Stat(l); err == nil {
+				size = info.Size()
+			}
+			fmt.Printf("  %s  %8s  %s\n", strings.TrimSuffix(filepath.Base(l), ".log"), formatSize(size), l)
+		}
+		return nil
+	}
+
+	path := logs[0]
+	if len(args) == 2 {
+		path, err = findLog(logs, args[1])
+		if err != nil {
+			return fmt.Errorf("%w (see 'pgx logs --list %s')", err, name)
+		}
+	}
+
+	if logsSummary {
+		fmt.Println(buildlog.Summarize(path))
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	os.Stdout.Write(data)
+	return nil
+}
+
+// findLog returns the log named name, or the only log whose name starts
+// with it.
+func findLog(logs []string, name string) (string, error) {
+	name = strings.TrimSuffix(name, ".log")
+	var matches []string
+	for _, l := range logs {
+		base := strings.TrimSuffix(filepath.Base(l), ".log")
+		if base == name {
+			return l, nil
+		}
+		if strings.HasPrefix(base, name) {
+			matches = append(matches, l)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("log not found: %s", name)
+	case 1:
+		return matches[0], nil
+	}
+	return "", fmt.Errorf("%s matches %d logs, give more of the name", name, len(matches))
+}
@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFindLog(t *testing.T) {
+	logs := []string{
+		"/logs/hello/20250102-090000.000000-1.log",
+		"/logs/hello/20250101-120000.000000-2.log",
+		"/logs/hello/20250101-113000.000000-3.log",
+	}
+	tests := []struct {
+		name    string
+		want    string
+		wantErr string
+	}{
+		{"20250102-090000.000000-1", logs[0], ""},
+		{"20250102-090000.000000-1.log", logs[0], ""},
+		{"20250102", logs[0], ""},
+		{"20250101-12", logs[1], ""},
+		{"20250101", "", "matches 2 logs"},
+		{"2024", "", "not found"},
+	}
+	for _, tt := range tests {
+		got, err := findLog(logs, tt.name)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("findLog(%q) error = %v, want one containing %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("findLog(%q) = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,364 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/matroidbe/pgbrew/internal/builder"
+	"github.com/matroidbe/pgbrew/internal/buildlog"
+	"github.com/matroidbe/pgbrew/internal/cellar"
+	"github.com/matroidbe/pgbrew/internal/config"
+	"github.com/matroidbe/pgbrew/internal/control"
+	"github.com/matroidbe/pgbrew/internal/pgrx"
+	"github.com/matroidbe/pgbrew/internal/tap"
+	"github.com/spf13/cobra"
+)
+
+var (
+	pgUpgradeFrom   string
+	pgUpgradeTo     string
+	pgUpgradeCheck  bool
+	pgUpgradeLatest bool
+)
+
+var pgUpgradeCmd = &cobra.Command{
+	Use:   "pg-upgrade --from <pg_config> --to <pg_config>",
+	Short: "Install the extensions of one PostgreSQL into a newer major version",
+	Long: `Prepare a new PostgreSQL major version for pg_upgrade: every extension
+installed by pgx into the old PostgreSQL is checked for support of the new
+version (the manifest's postgres range, the pgNN features of pgrx projects)
+and installed into the new one, at the same commit and with the same build
+options.
+
+The report lists the extensions that are ready, those that failed, the
+databases that need ALTER EXTENSION ... UPDATE after pg_upgrade because the
+new installation has another version, and extensions of the old PostgreSQL
+that pgx didn't install and that are missing from the new one.
+
+With --latest, the latest version of each source is installed instead,
+except for pinned extensions. With --check, nothing is installed.
+
+Examples:
+  pgx pg-upgrade --check --from /usr/lib/postgresql/15/bin/pg_config --to /usr/lib/postgresql/16/bin/pg_config
+  pgx pg-upgrade --sudo --from /usr/lib/postgresql/15/bin/pg_config --to /usr/lib/postgresql/16/bin/pg_config`,
+	Args: cobra.NoArgs,
+	RunE: runPgUpgrade,
+}
+
+func init() {
+	pgUpgradeCmd.Flags().StringVar(&pgUpgradeFrom, "from", "", "pg_config of the PostgreSQL to migrate from")
+	pgUpgradeCmd.Flags().StringVar(&pgUpgradeTo, "to", "", "pg_config of the PostgreSQL to install into")
+	pgUpgradeCmd.Flags().BoolVar(&pgUpgradeCheck, "check", false, "Only check that the extensions support the new version")
+	pgUpgradeCmd.Flags().BoolVar(&pgUpgradeLatest, "latest", false, "Install the latest version of each source instead of the installed commit")
+	pgUpgradeCmd.Flags().BoolVar(&useSudo, "sudo", false, "Use sudo for installation (needed for system PostgreSQL)")
+	pgUpgradeCmd.MarkFlagRequired("from")
+	pgUpgradeCmd.MarkFlagRequired("to")
+}
+
+// migration is the outcome of migrating one extension.
+type migration struct {
+	entry   cellar.Entry
+	version string // Version in the new PostgreSQL
+	note    string
+	err     error
+	updates []string // Databases to run ALTER EXTENSION ... UPDATE in, with their version
+}
+
+func runPgUpgrade(cmd *cobra.Command, args []string) error {
+	// Read the old installation
+	selectPgConfig(pgUpgradeFrom)
+	fromVersion := getPgVersion()
+	if fromVersion == "" {
+		return fmt.Errorf("could not run %s", pgUpgradeFrom)
+	}
+	entries, err := cellar.List()
+	if err != nil {
+		return fmt.Errorf("failed to read the cellar of PostgreSQL %s: %w", fromVersion, err)
+	}
+	fromExtDir := extensionDir()
+	entries = orderEntries(entries, fromExtDir)
+
+	// The databases of the old server, for the versions they have created
+	dbVersions := map[string]map[string]string{}
+	for _, e := range entries {
+		dbVersions[e.Name], _ = databaseVersions(e.Name)
+	}
+
+	selectPgConfig(pgUpgradeTo)
+	toVersion := getPgVersion()
+	if toVersion == "" {
+		return fmt.Errorf("could not run %s", pgUpgradeTo)
+	}
+	toExtDir := extensionDir()
+	if fromExtDir == toExtDir {
+		return fmt.Errorf("--from and --to are the same PostgreSQL installation")
+	}
+
+	fmt.Printf("Migrating extensions from PostgreSQL %s (%s)\n", fromVersion, pgUpgradeFrom)
+	fmt.Printf("                        to PostgreSQL %s (%s)\n\n", toVersion, pgUpgradeTo)
+	if len(entries) == 0 {
+		fmt.Printf("No extensions installed via pgbrew in %s\n", fromExtDir)
+	}
+
+	var results []migration
+	for _, e := range entries {
+		m := migration{entry: e}
+		m.version, m.note, m.err = migrateEntry(e, toVersion)
+		if m.err != nil {
+			fmt.Printf("✗ %v\n", m.err)
+		} else {
+			m.updates = pendingUpdates(dbVersions[e.Name], e.Version, m.version)
+		}
+		results = append(results, m)
+		fmt.Println()
+	}
+
+	missing := missingExtensions(fromExtDir, toExtDir, entries)
+	printMigrationReport(results, missing, toVersion)
+
+	for _, m := range results {
+		if m.err != nil {
+			return fmt.Errorf("not every extension is ready for PostgreSQL %s", toVersion)
+		}
+	}
+	return nil
+}
+
+// migrateEntry checks that an extension supports the new PostgreSQL and
+// installs it there. It returns the version installed and a note for the
+// report.
+func migrateEntry(e cellar.Entry, toVersion string) (version string, note string, err error) {
+	fmt.Printf("==> %s %s\n", e.Name, e.Version)
+
+	if existing, err := cellar.Get(e.Name); err == nil && existing.PgVersion == toVersion && !pgUpgradeLatest && existing.Commit == e.Commit && existing.Version == e.Version {
+		fmt.Println("Already installed")
+		return existing.Version, "already installed", nil
+	}
+
+	if !pgUpgradeCheck {
+		change := beginChange("pg-upgrade", useSudo)
+		defer func() { change.record(e.Name, "", err) }()
+	}
+
+	formula := entryFormula(e)
+	source, err := reinstallSource(e)
+	if err != nil {
+		return "", "", err
+	}
+	if pgUpgradeLatest && e.Pinned == "" && !isLocalPath(e.Source) {
+		if formula != nil {
+			source = formula.SourceAt("")
+		} else {
+			source, _, _ = strings.Cut(e.Source, "@")
+		}
+	}
+
+	log, err := buildlog.Start(e.Name)
+	if err != nil {
+		fmt.Printf("⚠ Could not create build log: %v\n", err)
+	}
+	defer log.Close()
+
+	src, err := prepareSource(source, log)
+	if err != nil {
+		return "", "", reportFailure(log, err)
+	}
+	defer src.Cleanup()
+	src.applyFormula(formula)
+	if pgUpgradeLatest {
+		src.Source = source
+	} else {
+		src.Source = e.Source
+	}
+
+	if err := checkSupport(src, toVersion); err != nil {
+		return "", "", err
+	}
+	if pgUpgradeCheck {
+		if src.Bottle != nil {
+			fmt.Printf("✓ Supports PostgreSQL %s (a prebuilt build for it is available)\n", toVersion)
+		} else {
+			fmt.Printf("✓ Supports PostgreSQL %s\n", toVersion)
+		}
+		return e.Version, "supported", nil
+	}
+
+	// Build with the options recorded in the old installation
+	useRecordedOptions(e.Options)
+	defer useRecordedOptions(nil)
+
+	if err := installFromSource(src); err != nil {
+		return "", "", reportFailure(log, err)
+	}
+	installed, err := cellar.Get(e.Name)
+	if err != nil {
+		return "", "", err
+	}
+	if e.Pinned != "" {
+		if err := cellar.SetPinned(e.Name, e.Pinned); err != nil {
+			fmt.Printf("⚠ Could not pin %s: %v\n", e.Name, err)
+		}
+	}
+	return installed.Version, "", nil
+}
+
+// checkSupport checks that a source declares support for a PostgreSQL major
+// version, before anything is built. Without the source, a prebuilt build
+// supports the version it was built for.
+func checkSupport(src *sourceTree, pgVersion string) error {
+	if src.Bottle != nil {
+		if src.Bottle.PgVersion != pgVersion {
+			return fmt.Errorf("the prebuilt build of %s is for PostgreSQL %s, not %s", src.Bottle.Name, src.Bottle.PgVersion, pgVersion)
+		}
+		return nil
+	}
+	if err := checkManifest(src.Manifest, pgVersion); err != nil {
+		return err
+	}
+	b, err := builder.DetectBuilderFor(src.Dir, src.Manifest)
+	if err != nil {
+		return err
+	}
+	if b.Name() == "pgrx" {
+		if supported, err := pgrx.SupportsPostgres(src.Dir, pgVersion); err == nil && !supported {
+			return fmt.Errorf("does not support PostgreSQL %s (Cargo.toml has no pg%s feature)", pgVersion, pgVersion)
+		}
+	}
+	return nil
+}
+
+// pendingUpdates returns the databases whose version of an extension differs
+// from the one installed into the new PostgreSQL. Without access to the old
+// server, the version it had installed stands in for the databases.
+func pendingUpdates(dbVersions map[string]string, oldVersion string, newVersion string) []string {
+	if newVersion == "" || newVersion == "unknown" {
+		return nil
+	}
+	if len(dbVersions) == 0 {
+		if oldVersion != newVersion {
+			return []string{fmt.Sprintf("each database (%s)", oldVersion)}
+		}
+		return nil
+	}
+
+	var dbs []string
+	for db, version := range dbVersions {
+		if version != newVersion {
+			dbs = append(dbs, fmt.Sprintf("%s (%s)", db, version))
+		}
+	}
+	sort.Strings(dbs)
+	return dbs
+}
+
+// missingExtensions returns the extensions of the old PostgreSQL that pgx
+// didn't install and that the new one doesn't have.
+func missingExtensions(fromExtDir string, toExtDir string, entries []cellar.Entry) []string {
+	tracked := map[string]bool{}
+	for _, e := range entries {
+		tracked[e.Name] = true
+	}
+	have := map[string]bool{}
+	if controls, err := control.List(toExtDir); err == nil {
+		for _, c := range controls {
+			have[c.Name] = true
+		}
+	}
+
+	var missing []string
+	controls, _ := control.List(fromExtDir)
+	for _, c := range controls {
+		if !tracked[c.Name] && !have[c.Name] {
+			missing = append(missing, c.Name)
+		}
+	}
+	return missing
+}
+
+func printMigrationReport(results []migration, missing []string, toVersion string) {
+	fmt.Printf("Report for PostgreSQL %s:\n", toVersion)
+
+	var failed, preload []string
+	var updates []migration
+	for _, m := range results {
+		if m.err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", m.entry.Name, strings.SplitN(m.err.Error(), "\n", 2)[0]))
+			continue
+		}
+		note := ""
+		if m.note != "" {
+			note = " (" + m.note + ")"
+		}
+		fmt.Printf("  ✓ %s %s%s\n", m.entry.Name, m.version, note)
+		if len(m.updates) > 0 {
+			updates = append(updates, m)
+		}
+		if m.entry.SharedPreload {
+			preload = append(preload, m.entry.Name)
+		}
+	}
+	for _, f := range failed {
+		fmt.Printf("  ✗ %s\n", f)
+	}
+
+	if len(updates) > 0 {
+		fmt.Println("\nAfter pg_upgrade, update these extensions:")
+		for _, m := range updates {
+			fmt.Printf("  %s → %s in %s:\n", m.entry.Name, m.version, strings.Join(m.updates, ", "))
+			fmt.Printf("    ALTER EXTENSION \"%s\" UPDATE;\n", m.entry.Name)
+		}
+	}
+	if len(preload) > 0 {
+		fmt.Println("\nKeep in shared_preload_libraries of the new server:")
+		fmt.Printf("  %s\n", strings.Join(preload, ", "))
+	}
+	if len(missing) > 0 {
+		fmt.Println("\n⚠ Not installed by pgx and missing from the new PostgreSQL (install them before pg_upgrade):")
+		for _, name := range missing {
+			fmt.Printf("  - %s\n", name)
+		}
+	}
+}
+
+// selectPgConfig makes a PostgreSQL the target of the commands that follow.
+func selectPgConfig(pgConfig string) {
+	config.Override("pg_config", pgConfig)
+	cellar.SetPgConfig(pgConfig)
+}
+
+// extensionDir returns the extension directory of the selected PostgreSQL.
+func extensionDir() string {
+	shareDir := strings.TrimSpace(getCommandOutput(getPgConfigPath(), "--sharedir"))
+	if shareDir == "" {
+		return ""
+	}
+	return filepath.Join(shareDir, "extension")
+}
+
+// useRecordedOptions makes the next install build with recorded options, as
+// if they had been given on the command line (nil clears them).
+func useRecordedOptions(o *cellar.BuildOptions) {
+	if o == nil {
+		o = &cellar.BuildOptions{}
+	}
+	installMakeArgs = o.MakeArgs
+	installCargoFeature = o.CargoFeatures
+	installEnv = o.Env
+	installCC = o.CC
+}
+
+// entryFormula returns the tap formula an entry was installed from, if it is
+// still available.
+func entryFormula(e cellar.Entry) *tap.Formula {
+	if e.Formula == "" {
+		return nil
+	}
+	f, err := tap.Find(e.Formula)
+	if err != nil || f == nil {
+		fmt.Printf("⚠ Formula %s is no longer available; building without it\n", e.Formula)
+		return nil
+	}
+	return f
+}
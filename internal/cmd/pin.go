@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/matroidbe/pgbrew/internal/cellar"
+	"github.com/spf13/cobra"
+)
+
+var pinUseSudo bool
+
+var pinCmd = &cobra.Command{
+	Use:   "pin <extension> [version]",
+	Short: "Keep an extension at its version",
+	Long: `Pin an installed extension to a version (the installed one by default).
+
+Installing a pinned extension at a different version fails unless --force is
+given. Pin extensions whose upgrades need planning, such as ones with on-disk
+format changes.
+
+Examples:
+  pgx pin vector
+  pgx pin vector 0.7.4`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: runPin,
+}
+
+var unpinCmd = &cobra.Command{
+	Use:   "unpin <extension>",
+	Short: "Allow an extension's version to change again",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runUnpin,
+}
+
+func init() {
+	pinCmd.Flags().BoolVar(&pinUseSudo, "sudo", false, "Use sudo to update the cellar (needed for system PostgreSQL)")
+	unpinCmd.Flags().BoolVar(&pinUseSudo, "sudo", false, "Use sudo to update the cellar (needed for system PostgreSQL)")
+}
+
+func runPin(cmd *cobra.Command, args []string) error {
+	entry, err := cellar.Get(args[0])
+	if err != nil {
+		return fmt.Errorf("extension %s is not installed via pgx", args[0])
+	}
+
+	version := entry.Version
+	if len(args) == 2 {
+		version = args[1]
+	}
+
+	cellar.SetUseSudo(pinUseSudo)
+	if err := cellar.SetPinned(entry.Name, version); err != nil {
+		return fmt.Errorf("failed to pin %s: %w", entry.Name, err)
+	}
+	fmt.Printf("✓ Pinned %s to %s\n", entry.Name, version)
+	if version != entry.Version {
+		fmt.Printf("⚠ %s %s is installed; install %s to match the pin\n", entry.Name, entry.Version, version)
+	}
+	return nil
+}
+
+func runUnpin(cmd *cobra.Command, args []string) error {
+	name := args[0]
+	pinned, err := cellar.Pinned(name)
+	if err != nil {
+		return err
+	}
+	if pinned == "" {
+		if _, err := cellar.Get(name); err != nil {
+			return fmt.Errorf("extension %s is not installed via pgx", name)
+		}
+		fmt.Printf("%s is not pinned\n", name)
+		return nil
+	}
+
+	cellar.SetUseSudo(pinUseSudo)
+	if err := cellar.SetPinned(name, ""); err != nil {
+		return fmt.Errorf("failed to unpin %s: %w", name, err)
+	}
+	fmt.Printf("✓ Unpinned %s (was pinned to %s)\n", name, pinned)
+	return nil
+}
+
+// checkPin refuses to install an extension at a version other than the one
+// it is pinned to, unless --force is given.
+func checkPin(name string, version string) error {
+	pinned, err := cellar.Pinned(name)
+	if err != nil || pinned == "" || pinned == version {
+		return nil
+	}
+	if installForce {
+		fmt.Printf("⚠ %s is pinned to %s; installing %s anyway (--force)\n", name, pinned, version)
+		return nil
+	}
+	return fmt.Errorf("%s is pinned to %s, refusing to install %s\n  Use --force to install anyway, or 'pgx unpin %s'", name, pinned, version, name)
+}
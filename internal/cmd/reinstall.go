@@ -0,0 +1,211 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/matroidbe/pgbrew/internal/builder"
+	"github.com/matroidbe/pgbrew/internal/buildlog"
+	"github.com/matroidbe/pgbrew/internal/cellar"
+	"github.com/matroidbe/pgbrew/internal/pgrx"
+	"github.com/spf13/cobra"
+)
+
+var (
+	reinstallAll      bool
+	reinstallOutdated bool
+)
+
+var reinstallCmd = &cobra.Command{
+	Use:   "reinstall <extension>... | --all",
+	Short: "Rebuild and reinstall extensions from their recorded source",
+	Long: `Rebuild installed extensions and install them again, from the source,
+commit and build options recorded when they were installed. Use it after a
+PostgreSQL upgrade or a change of system libraries.
+
+The build cache is not used, so every extension is built again.
+
+With --outdated, only extensions built for another PostgreSQL major version
+or with another compiler than this host now has are reinstalled.
+
+Examples:
+  pgx reinstall vector
+  pgx reinstall --all
+  pgx reinstall --all --outdated --sudo`,
+	RunE: runReinstall,
+}
+
+func init() {
+	reinstallCmd.Flags().BoolVar(&reinstallAll, "all", false, "Reinstall every extension installed by pgx")
+	reinstallCmd.Flags().BoolVar(&reinstallOutdated, "outdated", false, "Only reinstall extensions whose PostgreSQL version or toolchain has changed")
+	reinstallCmd.Flags().BoolVar(&useSudo, "sudo", false, "Use sudo for installation (needed for system PostgreSQL)")
+	reinstallCmd.Flags().BoolVar(&installSmokeTest, "smoke-test", false, "Check that each extension loads, using CREATE EXTENSION in a throwaway cluster")
+}
+
+// reinstallResult is the outcome of reinstalling one extension.
+type reinstallResult struct {
+	name    string
+	version string
+	skipped string // Why it was not reinstalled
+	err     error
+}
+
+func runReinstall(cmd *cobra.Command, args []string) error {
+	if reinstallAll == (len(args) > 0) {
+		return fmt.Errorf("give the extensions to reinstall, or --all")
+	}
+
+	entries, err := cellar.List()
+	if err != nil {
+		return fmt.Errorf("failed to read cellar: %w", err)
+	}
+
+	var results []reinstallResult
+	if !reinstallAll {
+		byName := map[string]cellar.Entry{}
+		for _, e := range entries {
+			byName[e.Name] = e
+		}
+		entries = nil
+		for _, name := range args {
+			e, ok := byName[name]
+			if !ok {
+				results = append(results, reinstallResult{name: name, err: fmt.Errorf("not installed by pgx")})
+				continue
+			}
+			entries = append(entries, e)
+		}
+	}
+	if len(entries) == 0 && len(results) == 0 {
+		fmt.Println("No extensions installed via pgbrew")
+		return nil
+	}
+
+	installRebuild = true
+	pgVersion := getPgVersion()
+	entries = orderEntries(entries, extensionDir())
+	for _, e := range entries {
+		if reinstallOutdated {
+			reason := outdatedReason(e, pgVersion)
+			if reason == "" {
+				results = append(results, reinstallResult{name: e.Name, version: e.Version, skipped: "up to date"})
+				continue
+			}
+			fmt.Printf("==> Reinstalling %s %s (%s)\n", e.Name, e.Version, reason)
+		} else {
+			fmt.Printf("==> Reinstalling %s %s\n", e.Name, e.Version)
+		}
+
+		err := reinstallEntry(e)
+		if err != nil {
+			fmt.Printf("✗ %v\n", err)
+		}
+		version := e.Version
+		if after, getErr := cellar.Get(e.Name); getErr == nil {
+			version = after.Version
+		}
+		results = append(results, reinstallResult{name: e.Name, version: version, err: err})
+		fmt.Println()
+	}
+
+	fmt.Println("Summary:")
+	var failed int
+	for _, r := range results {
+		switch {
+		case r.err != nil:
+			failed++
+			fmt.Printf("  ✗ %-20s %s\n", r.name, strings.SplitN(r.err.Error(), "\n", 2)[0])
+		case r.skipped != "":
+			fmt.Printf("  - %-20s %s, %s\n", r.name, r.version, r.skipped)
+		default:
+			fmt.Printf("  ✓ %-20s %s, reinstalled\n", r.name, r.version)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d reinstalls failed", failed, len(results))
+	}
+	return nil
+}
+
+// reinstallEntry builds and installs an extension again from its recorded
+// source, at its recorded commit. Its recorded build options are used, as
+// with any install that doesn't give options.
+func reinstallEntry(e cellar.Entry) (err error) {
+	change := beginChange("reinstall", useSudo)
+	defer func() { change.record(e.Name, "", err) }()
+
+	source, err := reinstallSource(e)
+	if err != nil {
+		return err
+	}
+
+	formula := entryFormula(e)
+
+	log, err := buildlog.Start(e.Name)
+	if err != nil {
+		fmt.Printf("⚠ Could not create build log: %v\n", err)
+	}
+	defer log.Close()
+
+	src, err := prepareSource(source, log)
+	if err != nil {
+		return reportFailure(log, err)
+	}
+	defer src.Cleanup()
+	src.Source = e.Source
+	src.applyFormula(formula)
+
+	if err := installFromSource(src); err != nil {
+		return reportFailure(log, err)
+	}
+	return nil
+}
+
+// reinstallSource returns the source to build an entry from: its recorded
+// source, pinned to the recorded commit.
+func reinstallSource(e cellar.Entry) (string, error) {
+	if isLocalPath(e.Source) {
+		if !filepath.IsAbs(e.Source) {
+			return "", fmt.Errorf("recorded source %s is a relative path; reinstall with 'pgx install <path>'", e.Source)
+		}
+		return e.Source, nil
+	}
+	if e.Commit == "" {
+		return e.Source, nil
+	}
+	repo, _, _ := strings.Cut(e.Source, "@")
+	return repo + "@" + e.Commit, nil
+}
+
+// outdatedReason explains why an installation doesn't match this host any
+// more ("" if it does).
+func outdatedReason(e cellar.Entry, pgVersion string) string {
+	if e.PgVersion != pgVersion {
+		return fmt.Sprintf("built for pg%s, pg_config is pg%s", e.PgVersion, pgVersion)
+	}
+	if e.Toolchain == "" {
+		return ""
+	}
+
+	switch e.BuildSystem {
+	case "pgxs":
+		opts := builder.InstallOptions{PgConfig: getPgConfigPath()}
+		if e.Options != nil {
+			opts.CC = e.Options.CC
+			opts.Env = e.Options.Env
+		}
+		if current := (&builder.PgxsBuilder{}).Toolchain("", opts); current != e.Toolchain {
+			return fmt.Sprintf("built with %s, now %s", e.Toolchain, current)
+		}
+	case "pgrx":
+		// cargo-pgrx is pinned by the project, so only the Rust compiler can
+		// change under it
+		_, recorded, _ := strings.Cut(e.Toolchain, ", ")
+		_, current, _ := strings.Cut(pgrx.Toolchain(""), ", ")
+		if recorded != current {
+			return fmt.Sprintf("built with %s, now %s", recorded, current)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/matroidbe/pgbrew/internal/cellar"
+)
+
+func TestReinstallSource(t *testing.T) {
+	tests := []struct {
+		entry   cellar.Entry
+		want    string
+		wantErr string
+	}{
+		{cellar.Entry{Source: "github.com/pgvector/pgvector", Commit: "abc123"}, "github.com/pgvector/pgvector@abc123", ""},
+		{cellar.Entry{Source: "github.com/pgvector/pgvector@v0.8.0", Commit: "abc123"}, "github.com/pgvector/pgvector@abc123", ""},
+		{cellar.Entry{Source: "github.com/pgvector/pgvector@v0.8.0"}, "github.com/pgvector/pgvector@v0.8.0", ""},
+		{cellar.Entry{Source: "/src/hello", Commit: "abc123"}, "/src/hello", ""},
+		{cellar.Entry{Source: "./hello"}, "", "relative path"},
+		{cellar.Entry{Source: "../hello", Commit: "abc123"}, "", "relative path"},
+	}
+	for _, tt := range tests {
+		got, err := reinstallSource(tt.entry)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("reinstallSource(%q) error = %v, want one containing %q", tt.entry.Source, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("reinstallSource(%q, %q) = %q, %v, want %q", tt.entry.Source, tt.entry.Commit, got, err, tt.want)
+		}
+	}
+}
+
+func TestOutdatedReason(t *testing.T) {
+	tests := []struct {
+		entry     cellar.Entry
+		pgVersion string
+		want      string
+	}{
+		{cellar.Entry{PgVersion: "16"}, "16", ""},
+		{cellar.Entry{PgVersion: "15"}, "16", "built for pg15, pg_config is pg16"},
+		{cellar.Entry{PgVersion: "15", BuildSystem: "pgxs", Toolchain: "gcc 12"}, "16", "built for pg15, pg_config is pg16"},
+		{cellar.Entry{PgVersion: "16", BuildSystem: "unknown", Toolchain: "gcc 12"}, "16", ""},
+	}
+	for _, tt := range tests {
+		if got := outdatedReason(tt.entry, tt.pgVersion); got != tt.want {
+			t.Errorf("outdatedReason(pg%s, %q) = %q, want %q", tt.entry.PgVersion, tt.pgVersion, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/matroidbe/pgbrew/internal/cellar"
+	"github.com/matroidbe/pgbrew/internal/config"
+	"github.com/spf13/cobra"
+)
+
+var rollbackList bool
+
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback <extension>",
+	Short: "Restore the previous installation of an extension",
+	Long: `Restore the files and cellar entry an extension had before its last install.
+
+Before an install replaces an extension, pgx saves the exact files it had
+installed. The number of saved installations per extension is set by the
+rollback_generations setting (default 3). The installation being replaced by
+a rollback is saved too, so a second rollback undoes the first.
+
+Databases that ran ALTER EXTENSION ... UPDATE to a version the restored files
+don't provide are reported.
+
+Examples:
+  pgx rollback --list vector
+  pgx rollback vector`,
+	Args: cobra.ExactArgs(1),
+	RunE: runRollback,
+}
+
+func init() {
+	rollbackCmd.Flags().BoolVar(&rollbackList, "list", false, "List the saved installations instead of restoring one")
+	rollbackCmd.Flags().BoolVar(&useSudo, "sudo", false, "Use sudo to change files (needed for system PostgreSQL)")
+	rollbackCmd.Flags().BoolVar(&installForce, "force", false, "Roll back even if the extension is pinned to another version")
+}
+
+func runRollback(cmd *cobra.Command, args []string) (err error) {
+	name := args[0]
+	gens, err := cellar.Generations(name)
+	if err != nil {
+		return err
+	}
+
+	if rollbackList {
+		if len(gens) == 0 {
+			fmt.Printf("No saved installations of %s\n", name)
+			return nil
+		}
+		for i, g := range gens {
+			fmt.Printf("%d  %s %-10s saved %s  (%d files)\n", i+1, g.Name, g.Version, g.SavedAt.Format("2006-01-02 15:04:05"), len(g.Files))
+		}
+		return nil
+	}
+
+	change := beginChange("rollback", useSudo)
+	defer func() { change.record(name, "", err) }()
+
+	if len(gens) == 0 {
+		return fmt.Errorf("no saved installation of %s to roll back to", name)
+	}
+	g := gens[0]
+	if err := checkPin(name, g.Version); err != nil {
+		return err
+	}
+
+	current, err := cellar.Get(name)
+	if err == nil {
+		fmt.Printf("Rolling back %s from %s to %s...\n", name, current.Version, g.Version)
+		if keep := config.Int("rollback_generations"); keep > 0 {
+			if _, err := cellar.Snapshot(*current, keep+1); err != nil {
+				return fmt.Errorf("failed to save the current installation: %w", err)
+			}
+		}
+	} else {
+		fmt.Printf("Restoring %s %s...\n", name, g.Version)
+	}
+
+	entry := g.Entry
+	entry.Files = nil
+	if _, err := linkTree(entry, g.TreeDir()); err != nil {
+		return err
+	}
+	if _, err := cellar.SaveKeg(g.Entry, g.TreeDir()); err != nil {
+		fmt.Printf("⚠ Could not keep %s %s in the store: %v\n", name, g.Version, err)
+	}
+	if err := cellar.RemoveGeneration(g); err != nil {
+		fmt.Printf("⚠ Could not remove the restored snapshot: %v\n", err)
+	}
+	fmt.Printf("✓ Restored %s %s (%d files, saved %s)\n", name, g.Version, len(g.Files), g.SavedAt.Format("2006-01-02 15:04"))
+
+	// Databases updated to a newer version need its scripts, which the
+	// restored files may not have
+	versions, err := databaseVersions(name)
+	if err != nil {
+		fmt.Printf("⚠ Could not check the versions created in databases: %v\n", err)
+	}
+	for db, version := range versions {
+		if !providesVersion(g.Files, name, version) {
+			fmt.Printf("⚠ Database %s has %s %s, which the restored files don't provide.\n", db, name, version)
+			fmt.Printf("  Roll forward again with 'pgx rollback %s', or install %s %s.\n", name, name, version)
+		}
+	}
+	return nil
+}
+
+// providesVersion reports whether an extension's files include a script
+// that installs or updates to version.
+func providesVersion(files []string, name string, version string) bool {
+	for _, f := range files {
+		base := filepath.Base(f)
+		if base == name+"--"+version+".sql" {
+			return true
+		}
+		if strings.HasPrefix(base, name+"--") && strings.HasSuffix(base, "--"+version+".sql") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,32 @@
+package cmd
+
+import "testing"
+
+func TestProvidesVersion(t *testing.T) {
+	files := []string{
+		"/usr/share/postgresql/16/extension/hello.control",
+		"/usr/share/postgresql/16/extension/hello--1.0.sql",
+		"/usr/share/postgresql/16/extension/hello--1.0--1.1.sql",
+		"/usr/share/postgresql/16/extension/hello_extra--2.0.sql",
+		"/usr/lib/postgresql/16/lib/hello.so",
+	}
+	tests := []struct {
+		version string
+		want    bool
+	}{
+		{"1.0", true},
+		{"1.1", true},
+		{"2.0", false},
+		{"1", false},
+		{"0", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := providesVersion(files, "hello", tt.version); got != tt.want {
+			t.Errorf("providesVersion(hello, %q) = %v, want %v", tt.version, got, tt.want)
+		}
+	}
+	if providesVersion(nil, "hello", "1.0") {
+		t.Error("providesVersion is true without files")
+	}
+}
@@ -1,12 +1,26 @@
 package cmd
 
 import (
+	"fmt"
+
+	"github.com/matroidbe/pgbrew/internal/buildlog"
+	"github.com/matroidbe/pgbrew/internal/cache"
+	"github.com/matroidbe/pgbrew/internal/cellar"
+	"github.com/matroidbe/pgbrew/internal/config"
+	"github.com/matroidbe/pgbrew/internal/github"
+	"github.com/matroidbe/pgbrew/internal/history"
+	"github.com/matroidbe/pgbrew/internal/offline"
+	"github.com/matroidbe/pgbrew/internal/pgxn"
+	"github.com/matroidbe/pgbrew/internal/release"
 	"github.com/spf13/cobra"
 )
 
 var (
 	// Version is set at build time
 	Version = "dev"
+
+	offlineFlag bool
+	verboseFlag bool
 )
 
 var rootCmd = &cobra.Command{
@@ -23,7 +37,45 @@ Manage installed extensions:
   pgx uninstall <extension>
 
 Check your system:
-  pgx doctor`,
+  pgx doctor
+
+Air-gapped hosts:
+  pgx fetch github.com/user/repo   # on a connected machine
+  pgx install --offline github.com/user/repo`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := config.Load(); err != nil {
+			// Still allow 'pgx config' to repair a broken file
+			if cmd.Parent() != configCmd {
+				return err
+			}
+			fmt.Printf("⚠ %v\n", err)
+		}
+
+		// The offline setting (or PGBREW_OFFLINE=1) makes offline mode the default
+		enabled := offlineFlag
+		if !cmd.Flags().Changed("offline") {
+			enabled = config.Bool("offline")
+		}
+		offline.SetEnabled(enabled)
+		buildlog.SetVerbose(verboseFlag)
+
+		cache.SetDir(config.String("cache_dir"))
+		cellar.SetPgConfig(getPgConfigPath())
+		github.SetMirrors(config.Section("mirrors"))
+		pgxn.SetBaseURL(config.String("pgxn_url"))
+		release.SetBaseURL(config.String("release_url"))
+		history.SetPath(config.String("history_file"))
+
+		// Settings provide the defaults of the matching command flags
+		for flag, key := range map[string]string{"sudo": "sudo", "bottle-dir": "bottle_dir"} {
+			if f := cmd.Flags().Lookup(flag); f != nil && !f.Changed {
+				if value := config.String(key); value != "" {
+					f.Value.Set(value)
+				}
+			}
+		}
+		return nil
+	},
 }
 
 func Execute() error {
@@ -31,6 +83,9 @@ func Execute() error {
 }
 
 func init() {
+	rootCmd.PersistentFlags().BoolVarP(&verboseFlag, "verbose", "v", false, "Stream build output instead of a progress line per step")
+	rootCmd.PersistentFlags().BoolVar(&offlineFlag, "offline", false, "Never access the network (default from the offline setting)")
+
 	rootCmd.AddCommand(versionCmd)
 	rootCmd.AddCommand(doctorCmd)
 	rootCmd.AddCommand(installCmd)
@@ -38,4 +93,24 @@ func init() {
 	rootCmd.AddCommand(infoCmd)
 	rootCmd.AddCommand(uninstallCmd)
 	rootCmd.AddCommand(upgradeCmd)
+	rootCmd.AddCommand(cacheCmd)
+	rootCmd.AddCommand(fetchCmd)
+	rootCmd.AddCommand(logsCmd)
+	rootCmd.AddCommand(testCmd)
+	rootCmd.AddCommand(verifyCmd)
+	rootCmd.AddCommand(configCmd)
+	rootCmd.AddCommand(tapCmd)
+	rootCmd.AddCommand(searchCmd)
+	rootCmd.AddCommand(pinCmd)
+	rootCmd.AddCommand(unpinCmd)
+	rootCmd.AddCommand(linkCmd)
+	rootCmd.AddCommand(unlinkCmd)
+	rootCmd.AddCommand(switchCmd)
+	rootCmd.AddCommand(rollbackCmd)
+	rootCmd.AddCommand(historyCmd)
+	rootCmd.AddCommand(cleanupCmd)
+	rootCmd.AddCommand(reinstallCmd)
+	rootCmd.AddCommand(pgUpgradeCmd)
+	rootCmd.AddCommand(depsCmd)
+	rootCmd.AddCommand(usesCmd)
 }
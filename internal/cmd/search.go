@@ -0,0 +1,211 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/matroidbe/pgbrew/internal/cellar"
+	"github.com/matroidbe/pgbrew/internal/offline"
+	"github.com/matroidbe/pgbrew/internal/pgxn"
+	"github.com/matroidbe/pgbrew/internal/tap"
+	"github.com/spf13/cobra"
+)
+
+var (
+	searchNoPgxn      bool
+	searchUpdateIndex bool
+)
+
+var searchCmd = &cobra.Command{
+	Use:   "search <term>",
+	Short: "Search taps and PGXN for extensions",
+	Long: `Search the formulas of the configured taps (by name, description and tags)
+and the PGXN index for extensions.
+
+The PGXN index holds the metadata of every distribution on PGXN, including
+their tags, and is searched locally. It is fetched on the first search and
+again when it is a week old (or with --update-index); offline, the cached
+index is used. The PGXN server can be changed with the pgxn_url setting.
+
+Examples:
+  pgx search vector
+  pgx search --no-pgxn search
+  pgx search --update-index fdw`,
+	Args: cobra.ExactArgs(1),
+	RunE: runSearch,
+}
+
+func init() {
+	searchCmd.Flags().BoolVar(&searchNoPgxn, "no-pgxn", false, "Only search the configured taps")
+	searchCmd.Flags().BoolVar(&searchUpdateIndex, "update-index", false, "Fetch the PGXN index now, even if the cached one is recent")
+}
+
+// searchResult is an extension found by pgx search.
+type searchResult struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version,omitempty"`
+	BuildSystem string `json:"build_system,omitempty"`
+	From        string `json:"from"`              // "tap <name>" or "pgxn"
+	Install     string `json:"install,omitempty"` // Argument for pgx install
+	Installed   string `json:"installed,omitempty"`
+	ViaPgx      bool   `json:"via_pgx,omitempty"`
+}
+
+func runSearch(cmd *cobra.Command, args []string) error {
+	term := strings.ToLower(args[0])
+	installed := installedVersions()
+	entries, _ := cellar.List()
+
+	var results []searchResult
+	taps, err := tap.List()
+	if err != nil {
+		return err
+	}
+	for _, t := range taps {
+		formulas, err := t.Formulas()
+		if err != nil {
+			fmt.Printf("⚠ tap %s: %v\n", t.Name, err)
+			continue
+		}
+		for _, f := range formulas {
+			if !formulaMatches(f, term) {
+				continue
+			}
+			r := searchResult{
+				Name:        f.Name,
+				Description: f.Description,
+				Version:     f.Ref,
+				BuildSystem: f.BuildSystem,
+				From:        "tap " + t.Name,
+				Install:     t.Name + "/" + f.Name,
+			}
+			// The extension name may differ from the formula's
+			for _, e := range entries {
+				if e.Formula == r.Install {
+					r.Installed, r.ViaPgx = e.Version, true
+				}
+			}
+			if r.Installed == "" {
+				r.Installed, r.ViaPgx = installed[f.Name].Version, installed[f.Name].ViaPgx
+			}
+			results = append(results, r)
+		}
+	}
+
+	var pgxnErr error
+	stale := false
+	if !searchNoPgxn {
+		if searchUpdateIndex {
+			if !jsonOutput() {
+				fmt.Println("Updating the PGXN index...")
+			}
+			_, pgxnErr = pgxn.Update()
+		} else if pgxn.NeedsUpdate() && !offline.Enabled() && !jsonOutput() {
+			fmt.Println("Updating the PGXN index...")
+		}
+
+		var hits []pgxn.Extension
+		if pgxnErr == nil {
+			hits, stale, pgxnErr = pgxn.Search(args[0])
+		}
+		for _, h := range hits {
+			results = append(results, searchResult{
+				Name:        h.Name,
+				Description: h.Description,
+				Version:     h.Version,
+				From:        "pgxn",
+				Installed:   installed[h.Name].Version,
+				ViaPgx:      installed[h.Name].ViaPgx,
+			})
+		}
+	}
+
+	if jsonOutput() {
+		return printJSON(results)
+	}
+
+	if pgxnErr != nil {
+		fmt.Printf("⚠ %v\n\n", pgxnErr)
+	} else if stale && offline.Enabled() {
+		fmt.Printf("⚠ Offline, showing cached PGXN results\n\n")
+	} else if stale {
+		fmt.Printf("⚠ PGXN unavailable, showing cached results\n\n")
+	}
+
+	if len(results) == 0 {
+		fmt.Printf("No extensions found for %q\n", args[0])
+		return nil
+	}
+
+	from := ""
+	for _, r := range results {
+		if r.From != from {
+			if from != "" {
+				fmt.Println()
+			}
+			from = r.From
+			fmt.Printf("From %s:\n", from)
+		}
+
+		version, buildSystem := r.Version, r.BuildSystem
+		if version == "" {
+			version = "-"
+		}
+		if buildSystem == "" {
+			buildSystem = "-"
+		}
+		status := ""
+		switch {
+		case r.Installed != "" && r.ViaPgx:
+			status = "✓ installed " + r.Installed
+		case r.Installed != "":
+			status = "✓ installed " + r.Installed + " (not via pgx)"
+		}
+		fmt.Printf("  %-24s %-10s %-6s %s\n", r.Name, version, buildSystem, status)
+		if r.Description != "" {
+			fmt.Printf("    %s\n", r.Description)
+		}
+		if r.Install != "" && r.Installed == "" {
+			fmt.Printf("    pgx install %s\n", r.Install)
+		}
+	}
+	return nil
+}
+
+// formulaMatches reports whether term (lowercase) appears in a formula's
+// name, description or tags.
+func formulaMatches(f tap.Formula, term string) bool {
+	if strings.Contains(strings.ToLower(f.Name), term) || strings.Contains(strings.ToLower(f.Description), term) {
+		return true
+	}
+	for _, tag := range f.Tags {
+		if strings.Contains(strings.ToLower(tag), term) {
+			return true
+		}
+	}
+	return false
+}
+
+// installedVersions returns the extensions available to the selected
+// PostgreSQL (as listed by 'pgx list --all'), by name.
+func installedVersions() map[string]extensionInfo {
+	installed := map[string]extensionInfo{}
+	shareDir := strings.TrimSpace(getCommandOutput(getPgConfigPath(), "--sharedir"))
+	if shareDir != "" {
+		controlFiles, _ := filepath.Glob(filepath.Join(shareDir, "extension", "*.control"))
+		for _, controlFile := range controlFiles {
+			ext := parseControlFile(controlFile)
+			installed[ext.Name] = ext
+		}
+	}
+
+	entries, _ := cellar.List()
+	for _, e := range entries {
+		ext := installed[e.Name]
+		ext.Name, ext.Version, ext.ViaPgx = e.Name, e.Version, true
+		installed[e.Name] = ext
+	}
+	return installed
+}
@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/matroidbe/pgbrew/internal/tap"
+	"github.com/spf13/cobra"
+)
+
+var tapCmd = &cobra.Command{
+	Use:   "tap",
+	Short: "Manage formula repositories (taps)",
+	Long: `Manage taps: repositories of formulas that let 'pgx install <name>' find
+an extension by name.
+
+A formula is a TOML file in the tap's Formula directory (or its root), named
+after the extension:
+
+  # Formula/pg_search.toml
+  description = "Full-text search with BM25"
+  source = "github.com/paradedb/paradedb"
+  subpath = "pg_search"
+  ref = "v0.15.0"
+  build_system = "pgrx"
+  shared_preload = true
+  tags = ["search"]
+
+  [options]
+  cargo_features = ["icu"]
+
+  [system_deps]
+  debian = ["libicu-dev"]
+
+Git taps are cloned; a local directory is used in place, which works offline.
+When several taps have a formula of the same name, the one added first wins;
+use <tap>/<name> to pick another.`,
+}
+
+var tapAddCmd = &cobra.Command{
+	Use:   "add <name> <git-url|github.com/user/repo|directory>",
+	Short: "Add a tap",
+	Args:  cobra.ExactArgs(2),
+	RunE:  runTapAdd,
+}
+
+var tapRemoveCmd = &cobra.Command{
+	Use:   "remove <name>",
+	Short: "Remove a tap",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runTapRemove,
+}
+
+var tapListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List taps and their formulas",
+	Args:  cobra.NoArgs,
+	RunE:  runTapList,
+}
+
+var tapUpdateCmd = &cobra.Command{
+	Use:   "update [name...]",
+	Short: "Pull the latest formulas of git taps",
+	RunE:  runTapUpdate,
+}
+
+func init() {
+	tapCmd.AddCommand(tapAddCmd)
+	tapCmd.AddCommand(tapRemoveCmd)
+	tapCmd.AddCommand(tapListCmd)
+	tapCmd.AddCommand(tapUpdateCmd)
+}
+
+func runTapAdd(cmd *cobra.Command, args []string) error {
+	t, err := tap.Add(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	formulas, err := t.Formulas()
+	if err != nil {
+		fmt.Printf("⚠ %v\n", err)
+	}
+	fmt.Printf("✓ Added tap %s (%d formulas)\n", t.Name, len(formulas))
+	return nil
+}
+
+func runTapRemove(cmd *cobra.Command, args []string) error {
+	if err := tap.Remove(args[0]); err != nil {
+		return err
+	}
+	fmt.Printf("✓ Removed tap %s\n", args[0])
+	return nil
+}
+
+func runTapList(cmd *cobra.Command, args []string) error {
+	taps, err := tap.List()
+	if err != nil {
+		return err
+	}
+	if len(taps) == 0 {
+		fmt.Println("No taps configured. Add one with 'pgx tap add <name> <url|directory>'.")
+		return nil
+	}
+
+	for _, t := range taps {
+		location := t.URL
+		if t.IsLocal() {
+			location = t.Path + " (local)"
+		}
+		fmt.Printf("%s  %s\n", t.Name, location)
+
+		formulas, err := t.Formulas()
+		if err != nil {
+			fmt.Printf("  ⚠ %v\n", err)
+			continue
+		}
+		for _, f := range formulas {
+			fmt.Printf("  %-24s %s\n", f.Name, f.Description)
+		}
+	}
+	return nil
+}
+
+func runTapUpdate(cmd *cobra.Command, args []string) error {
+	var taps []tap.Tap
+	if len(args) == 0 {
+		all, err := tap.List()
+		if err != nil {
+			return err
+		}
+		taps = all
+	} else {
+		for _, name := range args {
+			t, err := tap.Get(name)
+			if err != nil {
+				return err
+			}
+			taps = append(taps, *t)
+		}
+	}
+
+	failed := 0
+	for _, t := range taps {
+		if t.IsLocal() {
+			fmt.Printf("✓ %s is a local directory\n", t.Name)
+			continue
+		}
+		if err := tap.Update(t); err != nil {
+			fmt.Printf("✗ %s: %v\n", t.Name, err)
+			failed++
+			continue
+		}
+		fmt.Printf("✓ Updated %s\n", t.Name)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d tap(s) could not be updated", failed)
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/matroidbe/pgbrew/internal/builder"
+	"github.com/matroidbe/pgbrew/internal/buildlog"
+	"github.com/matroidbe/pgbrew/internal/cellar"
+	"github.com/matroidbe/pgbrew/internal/pgcluster"
+	"github.com/matroidbe/pgbrew/internal/tap"
+	"github.com/spf13/cobra"
+)
+
+var testInstall bool
+
+var testCmd = &cobra.Command{
+	Use:   "test <source|extension>",
+	Short: "Run an extension's regression tests",
+	Long: `Build an extension and run its own test suite against a temporary
+installation, without touching the selected PostgreSQL.
+
+The extension is built into a staging directory, which is layered over a
+throwaway copy of the installation described by pg_config (its binaries are
+copied, everything else is linked, and its own files of the extension are
+left out). Nothing is installed, recorded in the cellar or kept in the store.
+
+PGXS extensions: a cluster is created with the copy's initdb and started with
+its pg_ctl, and the REGRESS tests are run with 'make installcheck'. The
+cluster and the copy are deleted afterwards.
+
+pgrx extensions: #[pg_test] functions only run inside the harness of 'cargo
+pgrx test', which installs the extension and starts a cluster of its own, so
+pgx can't hand it one. pgx points the harness at the throwaway copy instead,
+through a private PGRX_HOME, so that is where the extension is installed.
+
+With --install, the extension is installed once its tests have passed (the
+same build, for PGXS extensions).
+
+Given the name of an installed extension, the source and commit it was
+installed from are tested.
+
+The exit status is non-zero if the tests fail, so it can gate deployments.
+PostgreSQL refuses to run as root, so run this as an unprivileged user.
+
+Examples:
+  pgx test github.com/pgvector/pgvector@v0.8.0
+  pgx test ./pg_hello
+  pgx test vector
+  pgx test --install --sudo github.com/pgvector/pgvector@v0.8.0`,
+	Args: cobra.ExactArgs(1),
+	RunE: runTest,
+}
+
+func init() {
+	testCmd.Flags().BoolVar(&testInstall, "install", false, "Install the extension if its tests pass")
+	testCmd.Flags().BoolVar(&useSudo, "sudo", false, "Use sudo for --install")
+	testCmd.Flags().BoolVar(&installNoCache, "no-cache", false, "Do not use or populate the build cache and checkout cache")
+}
+
+func runTest(cmd *cobra.Command, args []string) (err error) {
+	// Both test runners start a server, so fail before building anything
+	if err := pgcluster.CheckUser(); err != nil {
+		return err
+	}
+
+	source := args[0]
+	var formula *tap.Formula
+	if !isLocalPath(source) && !strings.Contains(source, "/") {
+		if entry, err := cellar.Get(source); err == nil {
+			source = installedSource(entry)
+		} else if source, formula, err = resolveSource(source); err != nil {
+			return err
+		} else if formula == nil && !strings.Contains(source, "/") {
+			return fmt.Errorf("extension %s is not installed (give a source to test instead)", source)
+		}
+	}
+
+	change := beginChange("test", useSudo)
+	var src *sourceTree
+	defer func() { change.record(src.nameOr(source), source, err) }()
+
+	log, err := buildlog.Start(logNameFor(source))
+	if err != nil {
+		fmt.Printf("⚠ Could not create build log: %v\n", err)
+	}
+	defer log.Close()
+
+	src, err = prepareSource(source, log)
+	if err != nil {
+		return reportFailure(log, err)
+	}
+	defer src.Cleanup()
+	src.applyFormula(formula)
+
+	if src.Bottle != nil {
+		return fmt.Errorf("tests need the extension's source, which is not available offline")
+	}
+
+	if err := testSource(src); err != nil {
+		return reportFailure(log, err)
+	}
+	return nil
+}
+
+// testSource runs the test suite of the extension in src against a
+// throwaway copy of the selected PostgreSQL, and installs it afterwards
+// with --install.
+func testSource(src *sourceTree) error {
+	b, err := builder.DetectBuilderFor(src.Dir, src.Manifest)
+	if err != nil {
+		return err
+	}
+
+	extName, err := b.GetExtensionName(src.Dir)
+	if err != nil {
+		return fmt.Errorf("failed to get extension name: %w", err)
+	}
+	src.Name = extName
+	src.Log.SetName(extName)
+
+	buildOpts := buildOptionsFor(extName, src.Formula)
+	opts := builder.InstallOptions{
+		PgConfig:      getPgConfigPath(),
+		MakeArgs:      buildOpts.MakeArgs,
+		CargoFeatures: buildOpts.CargoFeatures,
+		Env:           buildOpts.Env,
+		CC:            buildOpts.CC,
+		Manifest:      src.Manifest,
+		Log:           src.Log,
+	}
+
+	if !b.TestsNeedServer() {
+		// The test harness installs the extension into the copy itself
+		prefix, err := pgcluster.NewPrefix(opts.PgConfig, "", extName)
+		if err != nil {
+			return err
+		}
+		defer prefix.Remove()
+
+		fmt.Printf("Testing %s...\n", extName)
+		testOpts := opts
+		testOpts.PgConfig = prefix.PgConfig()
+		if err := b.Test(src.Dir, testOpts); err != nil {
+			return testError(extName, err)
+		}
+		fmt.Printf("\n✓ Tests passed for %s\n", extName)
+
+		if testInstall {
+			fmt.Println()
+			return installFromSource(src)
+		}
+		return nil
+	}
+
+	// Build into a staging tree, and test that tree in a copy of the installation
+	build, err := buildSource(src)
+	if err != nil {
+		return err
+	}
+	defer build.Cleanup()
+
+	prefix, err := pgcluster.NewPrefix(opts.PgConfig, build.TreeDir, extName)
+	if err != nil {
+		return err
+	}
+	defer prefix.Remove()
+	fmt.Println()
+
+	clusterOpts := pgcluster.Options{Log: src.Log}
+	if build.Entry.SharedPreload {
+		clusterOpts.Settings = map[string]string{"shared_preload_libraries": "'" + extName + "'"}
+	}
+	cluster, err := pgcluster.Start(prefix.PgConfig(), clusterOpts)
+	if err != nil {
+		return err
+	}
+	defer cluster.Stop()
+
+	opts.Env = append(opts.Env, cluster.Env()...)
+	if err := b.Test(src.Dir, opts); err != nil {
+		if !errors.Is(err, builder.ErrNoTests) {
+			printServerLog(cluster)
+		}
+		return testError(extName, err)
+	}
+	fmt.Printf("\n✓ Tests passed for %s\n", extName)
+
+	if testInstall {
+		fmt.Println()
+		return deploySource(src, build)
+	}
+	return nil
+}
+
+// printServerLog shows the end of a test cluster's server log, which has
+// crashes and load errors that client output doesn't explain.
+func printServerLog(cluster *pgcluster.Cluster) {
+	serverLog := cluster.ServerLog(20)
+	if serverLog == "" {
+		return
+	}
+	fmt.Println()
+	fmt.Println("Server log:")
+	for _, line := range strings.Split(serverLog, "\n") {
+		fmt.Printf("  %s\n", line)
+	}
+}
+
+// testError explains a failed test run.
+func testError(extName string, err error) error {
+	if errors.Is(err, builder.ErrNoTests) {
+		return fmt.Errorf("%s has no regression tests (REGRESS is not set in its Makefile)", extName)
+	}
+	return fmt.Errorf("tests failed for %s: %w", extName, err)
+}
+
+// installedSource returns the source an installed extension was built from,
+// pinned to the recorded commit for GitHub sources.
+func installedSource(entry *cellar.Entry) string {
+	if entry.Commit == "" || isLocalPath(entry.Source) {
+		return entry.Source
+	}
+	source := entry.Source
+	if idx := strings.LastIndex(source, "@"); idx != -1 {
+		source = source[:idx]
+	}
+	return source + "@" + entry.Commit
+}
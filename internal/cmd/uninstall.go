@@ -5,27 +5,40 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 
+	"github.com/matroidbe/pgbrew/internal/builder"
 	"github.com/matroidbe/pgbrew/internal/cellar"
+	"github.com/matroidbe/pgbrew/internal/control"
 	"github.com/spf13/cobra"
 )
 
 var (
-	uninstallDryRun bool
+	uninstallDryRun  bool
 	uninstallUseSudo bool
+	uninstallForce   bool
 )
 
 var uninstallCmd = &cobra.Command{
 	Use:   "uninstall <extension>",
 	Short: "Uninstall an extension",
-	Long: `Uninstall a PostgreSQL extension.
-
-Note: This removes the extension files but does not DROP the extension from databases.
-You should run DROP EXTENSION in each database before uninstalling.
+	Long: `Uninstall a PostgreSQL extension installed by pgx.
+
+The files recorded when the extension was installed are removed, along with
+its stored versions (see 'pgx link'). Uninstall refuses when:
+  - the extension was not installed by pgx
+  - another installed extension requires it
+  - its library is listed in shared_preload_libraries
+  - the server can't be asked about shared_preload_libraries or databases
+  - a database still has the extension (run DROP EXTENSION first)
+--force overrides all but the last. For an extension not installed by pgx,
+it removes its module (name.so, or PostgreSQL's module suffix), name.control
+and name--*.sql.
 
 Examples:
   pgx uninstall pg_kafka
+  pgx uninstall --dry-run pg_kafka
   pgx uninstall --sudo pg_kafka  # Uninstall with sudo for system PostgreSQL`,
 	Args: cobra.ExactArgs(1),
 	RunE: runUninstall,
@@ -34,26 +47,20 @@ Examples:
 func init() {
 	uninstallCmd.Flags().BoolVar(&uninstallDryRun, "dry-run", false, "Show what would be removed without deleting")
 	uninstallCmd.Flags().BoolVar(&uninstallUseSudo, "sudo", false, "Use sudo for uninstallation (needed for system PostgreSQL)")
+	uninstallCmd.Flags().BoolVar(&uninstallForce, "force", false, "Uninstall despite dependents, shared_preload_libraries, or files not installed by pgx")
 }
 
-func runUninstall(cmd *cobra.Command, args []string) error {
+func runUninstall(cmd *cobra.Command, args []string) (err error) {
 	name := args[0]
 
+	if !uninstallDryRun {
+		change := beginChange("uninstall", uninstallUseSudo)
+		defer func() { change.record(name, "", err) }()
+	}
+
 	// Set sudo mode for cellar operations
 	cellar.SetUseSudo(uninstallUseSudo)
 
-	// Check if extension is tracked by pgx
-	entry, err := cellar.Get(name)
-	tracked := err == nil
-
-	if uninstallDryRun {
-		fmt.Printf("Dry run: would uninstall %s\n\n", name)
-	} else if tracked {
-		fmt.Printf("Uninstalling %s %s...\n", entry.Name, entry.Version)
-	} else {
-		fmt.Printf("Uninstalling %s...\n", name)
-	}
-
 	// Get PostgreSQL directories
 	pgConfigPath := getPgConfigPath()
 	libDir := strings.TrimSpace(getCommandOutput(pgConfigPath, "--pkglibdir"))
@@ -64,40 +71,57 @@ func runUninstall(cmd *cobra.Command, args []string) error {
 	}
 
 	extDir := filepath.Join(shareDir, "extension")
+	suffix := builder.ModuleSuffix(pgConfigPath)
 
-	// Find extension files
-	var files []string
-
-	// .so file from lib directory
-	soFile := filepath.Join(libDir, name+".so")
-	if _, err := os.Stat(soFile); err == nil {
-		files = append(files, soFile)
-	}
+	// Check if extension is tracked by pgx
+	entry, err := cellar.Get(name)
+	tracked := err == nil
+	kegs, _ := cellar.Kegs(name)
 
-	// .control file
-	controlFile := filepath.Join(extDir, name+".control")
-	if _, err := os.Stat(controlFile); err == nil {
-		files = append(files, controlFile)
+	// Only remove the files pgx installed, unless forced
+	var files []string
+	switch {
+	case tracked && len(entry.Files) > 0:
+		files = entry.Files
+	case tracked || uninstallForce:
+		// Installed before file manifests were recorded, or not by pgx
+		files = guessExtensionFiles(name, libDir, extDir, suffix)
+	case len(kegs) == 0:
+		if len(guessExtensionFiles(name, libDir, extDir, suffix)) > 0 {
+			return fmt.Errorf("%s was not installed by pgx; use --force to remove its files anyway", name)
+		}
+		return fmt.Errorf("extension %s is not installed", name)
 	}
 
-	// SQL files (pattern: name--*.sql)
-	sqlPattern := filepath.Join(extDir, name+"--*.sql")
-	sqlFiles, _ := filepath.Glob(sqlPattern)
-	files = append(files, sqlFiles...)
-
-	// Also try name.sql (some extensions use this)
-	sqlFile := filepath.Join(extDir, name+".sql")
-	if _, err := os.Stat(sqlFile); err == nil {
-		files = append(files, sqlFile)
+	if uninstallDryRun {
+		fmt.Printf("Dry run: would uninstall %s\n\n", name)
+	} else if tracked {
+		fmt.Printf("Uninstalling %s %s...\n", entry.Name, entry.Version)
+	} else {
+		fmt.Printf("Uninstalling %s...\n", name)
 	}
 
-	if len(files) == 0 {
-		fmt.Println("No extension files found.")
-		return nil
+	// Check what would break
+	var problems []string
+	if len(files) > 0 {
+		if dependents, _ := control.Dependents(extDir, name); len(dependents) > 0 {
+			problems = append(problems, fmt.Sprintf("Required by installed extension(s): %s (see 'pgx uses %s')", strings.Join(dependents, ", "), name))
+		}
+		preloaded, err := preloadedModules(name, files, suffix)
+		if err != nil {
+			// The library may be preloaded; removing it would stop the server from starting
+			problems = append(problems, fmt.Sprintf("Could not check shared_preload_libraries: %v (start PostgreSQL, or check it by hand)", err))
+		}
+		if len(preloaded) > 0 {
+			problems = append(problems, fmt.Sprintf("Listed in shared_preload_libraries: %s (remove it and restart PostgreSQL first)", strings.Join(preloaded, ", ")))
+		}
 	}
 
 	// Check which databases have this extension installed
-	activeDbs := findDatabasesWithExtension(name)
+	activeDbs, err := findDatabasesWithExtension(name)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("Could not check which databases have %s: %v (start PostgreSQL, or check it by hand)", name, err))
+	}
 
 	// Dry run: just show what would be removed
 	if uninstallDryRun {
@@ -108,6 +132,12 @@ func runUninstall(cmd *cobra.Command, args []string) error {
 			}
 			fmt.Println()
 		}
+		for _, p := range problems {
+			fmt.Printf("⚠ %s\n", p)
+		}
+		if len(problems) > 0 {
+			fmt.Println()
+		}
 
 		fmt.Printf("Would remove %d files:\n", len(files))
 		for _, f := range files {
@@ -116,9 +146,19 @@ func runUninstall(cmd *cobra.Command, args []string) error {
 		if tracked {
 			fmt.Println("\nWould remove from pgx tracking.")
 		}
+		if len(kegs) > 0 {
+			fmt.Printf("Would remove %d stored version(s).\n", len(kegs))
+		}
 		return nil
 	}
 
+	if len(problems) > 0 && !uninstallForce {
+		for _, p := range problems {
+			fmt.Printf("✗ %s\n", p)
+		}
+		return fmt.Errorf("cannot uninstall %s (use --force to override)", name)
+	}
+
 	// Block uninstall if extension is active in any database
 	if len(activeDbs) > 0 {
 		fmt.Printf("Error: Extension is active in %d database(s):\n", len(activeDbs))
@@ -134,41 +174,151 @@ func runUninstall(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("cannot uninstall: extension is still active")
 	}
 
-	// Actually remove files
-	var removed []string
+	// Actually remove files; keep the cellar entry if any remain, so the
+	// uninstall can be retried (e.g. with --sudo)
+	var present []string
 	for _, f := range files {
-		var err error
-		if uninstallUseSudo {
-			// Use sudo to remove file
-			rmCmd := exec.Command("sudo", "rm", "-f", f)
-			err = rmCmd.Run()
-		} else {
-			err = os.Remove(f)
-		}
-		if err == nil {
+		if _, err := os.Lstat(f); err == nil {
+			present = append(present, f)
+		}
+	}
+	removeErr := builder.RemoveFiles(present, uninstallUseSudo)
+	var removed []string
+	for _, f := range present {
+		if _, err := os.Lstat(f); os.IsNotExist(err) {
 			removed = append(removed, f)
 		}
 	}
+	if len(removed) > 0 {
+		fmt.Printf("✓ Removed %d files:\n", len(removed))
+		for _, f := range removed {
+			fmt.Printf("  - %s\n", f)
+		}
+	}
+	if removeErr != nil {
+		fmt.Printf("✗ Could not remove %d file(s):\n", len(present)-len(removed))
+		for _, line := range strings.Split(removeErr.Error(), "\n") {
+			fmt.Printf("  %s\n", line)
+		}
+		return fmt.Errorf("uninstall of %s is incomplete", name)
+	}
 
-	// Remove from cellar tracking if it was tracked
-	if tracked {
+	// Remove from cellar tracking if it was tracked, along with the pin an
+	// unlinked extension keeps
+	pinned, _ := cellar.Pinned(name)
+	if tracked || pinned != "" {
 		if err := cellar.Remove(name); err != nil {
 			return fmt.Errorf("failed to remove from cellar: %w", err)
 		}
 	}
 
-	if len(removed) > 0 {
-		fmt.Printf("✓ Removed %d files:\n", len(removed))
-		for _, f := range removed {
-			fmt.Printf("  - %s\n", f)
+	// Stored versions go too; 'pgx unlink' keeps them
+	if len(kegs) > 0 {
+		if err := cellar.RemoveKegs(name); err != nil {
+			return fmt.Errorf("failed to remove stored versions: %w", err)
 		}
+		fmt.Printf("✓ Removed %d stored version(s)\n", len(kegs))
 	}
 
 	return nil
 }
 
+// guessExtensionFiles finds the usual files of an extension that has no
+// recorded file manifest: its module (name plus the module suffix, e.g.
+// name.so), name.control and its SQL scripts.
+func guessExtensionFiles(name string, libDir string, extDir string, suffix string) []string {
+	var files []string
+
+	// Module from lib directory
+	module := filepath.Join(libDir, name+suffix)
+	if _, err := os.Stat(module); err == nil {
+		files = append(files, module)
+	}
+
+	// .control file
+	controlFile := filepath.Join(extDir, name+".control")
+	if _, err := os.Stat(controlFile); err == nil {
+		files = append(files, controlFile)
+	}
+
+	// SQL files (pattern: name--*.sql)
+	sqlFiles, _ := filepath.Glob(filepath.Join(extDir, name+"--*.sql"))
+	files = append(files, sqlFiles...)
+
+	// Also try name.sql (some extensions use this)
+	sqlFile := filepath.Join(extDir, name+".sql")
+	if _, err := os.Stat(sqlFile); err == nil {
+		files = append(files, sqlFile)
+	}
+
+	return files
+}
+
+// preloadedModules returns the entries of the running server's
+// shared_preload_libraries that load the extension's modules (or a module
+// named after the extension).
+func preloadedModules(name string, files []string, suffix string) ([]string, error) {
+	libraries, err := sharedPreloadLibraries()
+	if err != nil {
+		return nil, err
+	}
+	return matchPreloaded(name, files, libraries, suffix), nil
+}
+
+// matchPreloaded returns the entries of libraries (shared_preload_libraries)
+// that load one of the modules in files, or a module named name. Entries
+// may name a module with or without a directory and the module suffix.
+func matchPreloaded(name string, files []string, libraries []string, suffix string) []string {
+	modules := map[string]bool{name: true}
+	for _, f := range files {
+		if builder.IsModule(f, suffix) {
+			modules[builder.ModuleName(f, suffix)] = true
+		}
+	}
+
+	var preloaded []string
+	for _, lib := range libraries {
+		if modules[builder.ModuleName(lib, suffix)] {
+			preloaded = append(preloaded, lib)
+		}
+	}
+	return preloaded
+}
+
+// sharedPreloadLibraries returns the running server's shared_preload_libraries.
+func sharedPreloadLibraries() ([]string, error) {
+	cmd := exec.Command(getPsqlPath(), "-t", "-A", "-d", "postgres", "-c", "SHOW shared_preload_libraries")
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("could not query the server: %w", err)
+	}
+
+	var libraries []string
+	for _, lib := range strings.Split(strings.TrimSpace(string(output)), ",") {
+		if lib = strings.Trim(strings.TrimSpace(lib), `"`); lib != "" {
+			libraries = append(libraries, lib)
+		}
+	}
+	return libraries, nil
+}
+
 // findDatabasesWithExtension returns a list of database names that have the extension installed
-func findDatabasesWithExtension(extName string) []string {
+func findDatabasesWithExtension(extName string) ([]string, error) {
+	versions, err := databaseVersions(extName)
+	if err != nil {
+		return nil, err
+	}
+	activeDbs := make([]string, 0, len(versions))
+	for db := range versions {
+		activeDbs = append(activeDbs, db)
+	}
+	sort.Strings(activeDbs)
+	return activeDbs, nil
+}
+
+// databaseVersions returns the version of the extension created in each
+// database that has it. It fails if the server can't be queried.
+func databaseVersions(extName string) (map[string]string, error) {
 	// Get psql path from the same PostgreSQL installation
 	psqlPath := getPsqlPath()
 
@@ -176,11 +326,11 @@ func findDatabasesWithExtension(extName string) []string {
 	cmd := exec.Command(psqlPath, "-t", "-A", "-d", "postgres", "-c", "SELECT datname FROM pg_database WHERE datistemplate = false")
 	output, err := cmd.Output()
 	if err != nil {
-		return nil
+		return nil, fmt.Errorf("could not query the server: %w", err)
 	}
 
 	databases := strings.Split(strings.TrimSpace(string(output)), "\n")
-	var activeDbs []string
+	versions := make(map[string]string)
 
 	// Check each database for the extension
 	for _, db := range databases {
@@ -189,24 +339,24 @@ func findDatabasesWithExtension(extName string) []string {
 			continue
 		}
 
-		query := fmt.Sprintf("SELECT 1 FROM pg_extension WHERE extname = '%s'", extName)
+		query := fmt.Sprintf("SELECT extversion FROM pg_extension WHERE extname = '%s'", extName)
 		cmd := exec.Command(psqlPath, "-t", "-A", "-d", db, "-c", query)
 		output, err := cmd.Output()
 		if err != nil {
 			continue
 		}
 
-		if strings.TrimSpace(string(output)) == "1" {
-			activeDbs = append(activeDbs, db)
+		if version := strings.TrimSpace(string(output)); version != "" {
+			versions[db] = version
 		}
 	}
 
-	return activeDbs
+	return versions, nil
 }
 
-// getPsqlPath returns the path to psql, deriving it from PG_CONFIG if set
+// getPsqlPath returns the path to psql, deriving it from the configured pg_config
 func getPsqlPath() string {
-	if pgConfig := os.Getenv("PG_CONFIG"); pgConfig != "" {
+	if pgConfig := getPgConfigPath(); pgConfig != "pg_config" {
 		// pg_config is usually in the same bin directory as psql
 		binDir := filepath.Dir(pgConfig)
 		psqlPath := filepath.Join(binDir, "psql")
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatchPreloaded(t *testing.T) {
+	files := []string{
+		"/usr/lib/postgresql/16/lib/pg_search.so",
+		"/usr/lib/postgresql/16/lib/pg_search_worker.so",
+		"/usr/share/postgresql/16/extension/pg_search.control",
+	}
+	tests := []struct {
+		name      string
+		files     []string
+		libraries []string
+		suffix    string
+		want      []string
+	}{
+		{"pg_search", files, []string{"pg_search"}, ".so", []string{"pg_search"}},
+		{"pg_search", files, []string{"pg_stat_statements", "pg_search_worker"}, ".so", []string{"pg_search_worker"}},
+		{"pg_search", files, []string{"$libdir/pg_search.so", "pg_search_worker.so"}, ".so", []string{"$libdir/pg_search.so", "pg_search_worker.so"}},
+		{"pg_search", files, []string{"pg_stat_statements", "pg_search.control"}, ".so", nil},
+		{"pg_search", nil, []string{"pg_search"}, ".so", []string{"pg_search"}},
+		{"pg_search", nil, []string{"pg_search_worker"}, ".so", nil},
+		{"pg_search", []string{"/opt/pg/lib/pg_search_worker.dylib"}, []string{"pg_search_worker.dylib", "pg_search.so"}, ".dylib", []string{"pg_search_worker.dylib"}},
+		{"pg_search", files, nil, ".so", nil},
+	}
+	for _, tt := range tests {
+		if got := matchPreloaded(tt.name, tt.files, tt.libraries, tt.suffix); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("matchPreloaded(%q, %v, %v, %q) = %v, want %v", tt.name, tt.files, tt.libraries, tt.suffix, got, tt.want)
+		}
+	}
+}
@@ -6,27 +6,92 @@ import (
 	"os/exec"
 	"path/filepath"
 
+	"github.com/matroidbe/pgbrew/internal/config"
+	"github.com/matroidbe/pgbrew/internal/offline"
+	"github.com/matroidbe/pgbrew/internal/release"
 	"github.com/spf13/cobra"
 )
 
+var (
+	upgradeVersion    string
+	upgradeCheck      bool
+	upgradeFromSource bool
+)
+
 var upgradeCmd = &cobra.Command{
 	Use:   "upgrade",
 	Short: "Upgrade pgx to the latest version",
-	Long:  `Download and install the latest version of pgx from GitHub.`,
-	RunE:  runUpgrade,
+	Long: `Download the latest pgx release binary for this OS and architecture and
+replace the running pgx with it. The binary is checked against the release's
+checksums file, whose ed25519 signature must match the release public key and
+which must name the release. A latest release older than the running pgx is
+refused; install an older release explicitly with --version.
+
+With --from-source, pgx is built from the GitHub repository instead (this
+needs Go and Git).
+
+The release location is the release_url setting, which may also be a local
+directory with the same layout (usable offline). It and release_public_key
+are only read from the system configuration, /etc/pgbrew/config.toml:
+  <release_url>/latest/download/VERSION
+  <release_url>/download/<tag>/{pgx_<os>_<arch>,checksums.txt,checksums.txt.sig}
+
+Examples:
+  pgx upgrade
+  pgx upgrade --check
+  pgx upgrade --version v0.4.0
+  pgx upgrade --from-source`,
+	RunE: runUpgrade,
+}
+
+func init() {
+	upgradeCmd.Flags().StringVar(&upgradeVersion, "version", "", "Release to install instead of the latest")
+	upgradeCmd.Flags().BoolVar(&upgradeCheck, "check", false, "Only report whether a newer release is available")
+	upgradeCmd.Flags().BoolVar(&upgradeFromSource, "from-source", false, "Build pgx from source instead of downloading a release")
 }
 
 func runUpgrade(cmd *cobra.Command, args []string) error {
-	fmt.Println("Upgrading pgx...")
+	if offline.Enabled() && (upgradeFromSource || release.IsRemote()) {
+		return offline.Missing("the latest pgx release", "Upgrade pgx on a connected machine and copy the binary to this host.")
+	}
+	if err := release.SetPublicKey(config.String("release_public_key")); err != nil {
+		return err
+	}
 
-	// Check for Go
-	if _, err := exec.LookPath("go"); err != nil {
-		return fmt.Errorf("Go is required but not installed. Install from https://go.dev/dl/")
+	tag := upgradeVersion
+	if tag == "" && (upgradeCheck || !upgradeFromSource) {
+		latest, err := release.Latest()
+		if err != nil {
+			return err
+		}
+		tag = latest
+	}
+	downgrade := upgradeVersion == "" && tag != "" && release.IsDowngrade(tag, Version)
+
+	if upgradeCheck {
+		fmt.Printf("Installed: %s\n", Version)
+		fmt.Printf("Release:   %s\n", tag)
+		if _, err := release.Checksum(tag); err != nil {
+			fmt.Printf("✗ No verified binary for %s: %v\n", release.AssetName(), err)
+			fmt.Println("  Use 'pgx upgrade --from-source' to build it instead")
+			return nil
+		}
+		if tag == Version {
+			fmt.Println("✓ pgx is up to date")
+		} else if downgrade {
+			fmt.Printf("⚠ The latest release (%s) is older than this pgx (%s)\n", tag, Version)
+		} else {
+			fmt.Printf("⚠ pgx %s is available, run 'pgx upgrade%s' to install it\n", tag, versionFlag(upgradeVersion))
+		}
+		return nil
 	}
 
-	// Check for Git
-	if _, err := exec.LookPath("git"); err != nil {
-		return fmt.Errorf("Git is required but not installed")
+	if tag != "" && tag == Version {
+		fmt.Printf("✓ pgx %s is already installed\n", Version)
+		return nil
+	}
+	if downgrade {
+		return fmt.Errorf("the latest release (%s) is older than the installed pgx %s; use --version %s to install it anyway", tag, Version, tag)
 	}
 
 	// Create temp directory
@@ -36,20 +101,20 @@ func runUpgrade(cmd *cobra.Command, args []string) error {
 	}
 	defer os.RemoveAll(tmpDir)
 
-	// Clone repository
-	repo := "https://github.com/matroidbe/pgbrew.git"
-	fmt.Println("Fetching latest version...")
-	cloneCmd := exec.Command("git", "clone", "--depth", "1", repo, tmpDir)
-	if output, err := cloneCmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to clone repository: %s\n%s", err, string(output))
+	var newExe string
+	if upgradeFromSource {
+		newExe, err = buildFromSource(tag, tmpDir)
+	} else {
+		fmt.Printf("Downloading pgx %s (%s)...\n", tag, release.AssetName())
+		newExe, err = release.Download(tag, tmpDir)
+		if err != nil {
+			err = fmt.Errorf("%w\nUse 'pgx upgrade --from-source' to build pgx instead", err)
+		} else {
+			fmt.Println("✓ Signature and checksum verified")
+		}
 	}
-
-	// Build
-	fmt.Println("Building...")
-	buildCmd := exec.Command("go", "build", "-o", "pgx", "./cmd/pgx")
-	buildCmd.Dir = tmpDir
-	if output, err := buildCmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to build: %s\n%s", err, string(output))
+	if err != nil {
+		return err
 	}
 
 	// Find current executable path
@@ -63,7 +128,6 @@ func runUpgrade(cmd *cobra.Command, args []string) error {
 	}
 
 	// Replace current executable
-	newExe := filepath.Join(tmpDir, "pgx")
 	fmt.Printf("Installing to %s...\n", currentExe)
 
 	// On Linux, we can't overwrite a running binary directly.
@@ -88,10 +152,65 @@ func runUpgrade(cmd *cobra.Command, args []string) error {
 	// Clean up old executable
 	os.Remove(oldExe)
 
-	fmt.Println("✓ pgx upgraded successfully!")
+	if tag != "" {
+		fmt.Printf("✓ pgx upgraded to %s\n", tag)
+	} else {
+		fmt.Println("✓ pgx upgraded successfully!")
+	}
 	return nil
 }
 
+// buildFromSource builds pgx from the repository in dir, at a release tag or
+// the default branch, and returns the path of the binary.
+func buildFromSource(tag string, dir string) (string, error) {
+	// Check for Go
+	if _, err := exec.LookPath("go"); err != nil {
+		return "", fmt.Errorf("Go is required but not installed. Install from https://go.dev/dl/")
+	}
+
+	// Check for Git
+	if _, err := exec.LookPath("git"); err != nil {
+		return "", fmt.Errorf("Git is required but not installed")
+	}
+
+	// Clone repository
+	repo := "https://github.com/matroidbe/pgbrew.git"
+	cloneArgs := []string{"clone", "--depth", "1"}
+	if tag != "" {
+		fmt.Printf("Fetching %s...\n", tag)
+		cloneArgs = append(cloneArgs, "--branch", tag)
+	} else {
+		fmt.Println("Fetching latest version...")
+	}
+	srcDir := filepath.Join(dir, "src")
+	cloneCmd := exec.Command("git", append(cloneArgs, repo, srcDir)...)
+	if output, err := cloneCmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("failed to clone repository: %s\n%s", err, string(output))
+	}
+
+	// Build
+	fmt.Println("Building...")
+	version := tag
+	if version == "" {
+		version = "dev"
+	}
+	exe := filepath.Join(dir, "pgx")
+	buildCmd := exec.Command("go", "build", "-ldflags", "-X github.com/matroidbe/pgbrew/internal/cmd.Version="+version, "-o", exe, "./cmd/pgx")
+	buildCmd.Dir = srcDir
+	if output, err := buildCmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("failed to build: %s\n%s", err, string(output))
+	}
+	return exe, nil
+}
+
+// versionFlag returns the --version flag for a requested release.
+func versionFlag(version string) string {
+	if version == "" {
+		return ""
+	}
+	return " --version " + version
+}
+
 // copyFile copies a file from src to dst with executable permissions
 func copyFile(src, dst string) error {
 	input, err := os.ReadFile(src)
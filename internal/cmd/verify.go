@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/matroidbe/pgbrew/internal/builder"
+	"github.com/matroidbe/pgbrew/internal/cellar"
+	"github.com/matroidbe/pgbrew/internal/sharedlib"
+	"github.com/spf13/cobra"
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify [extension...]",
+	Short: "Check that installed extensions are intact and loadable",
+	Long: `Check installed extensions without starting PostgreSQL.
+
+For each extension (all installed ones if none are given), verifies that
+every installed file is still present and that the system libraries its
+shared modules link against can be found by the dynamic loader.
+
+Examples:
+  pgx verify
+  pgx verify pg_kafka`,
+	RunE: runVerify,
+}
+
+func runVerify(cmd *cobra.Command, args []string) error {
+	var entries []cellar.Entry
+	if len(args) == 0 {
+		all, err := cellar.List()
+		if err != nil {
+			return fmt.Errorf("failed to list installed extensions: %w", err)
+		}
+		entries = all
+	} else {
+		for _, name := range args {
+			entry, err := cellar.Get(name)
+			if err != nil {
+				return fmt.Errorf("extension %s is not installed", name)
+			}
+			entries = append(entries, *entry)
+		}
+	}
+
+	if len(entries) == 0 {
+		fmt.Println("No extensions installed via pgx.")
+		return nil
+	}
+
+	failed := 0
+	for _, entry := range entries {
+		var problems []string
+		for _, f := range entry.Files {
+			if _, err := os.Stat(f); err != nil {
+				problems = append(problems, fmt.Sprintf("missing file %s", f))
+			}
+		}
+
+		missing := missingLibraries(entry)
+
+		// Only ELF modules can be inspected for library dependencies
+		var unchecked []string
+		for _, module := range moduleFiles(entry) {
+			if !sharedlib.IsELF(module) {
+				unchecked = append(unchecked, filepath.Base(module))
+			}
+		}
+
+		if len(problems) == 0 && len(missing) == 0 {
+			fmt.Printf("✓ %s %s\n", entry.Name, entry.Version)
+			for _, module := range unchecked {
+				fmt.Printf("  ⚠ %s: library dependencies not checked (not an ELF module)\n", module)
+			}
+			continue
+		}
+		failed++
+		fmt.Printf("✗ %s %s\n", entry.Name, entry.Version)
+		for _, p := range problems {
+			fmt.Printf("  %s\n", p)
+		}
+		printMissingLibraries(missing, "  ")
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d extension(s) have problems", failed)
+	}
+	return nil
+}
+
+// missingLibrary is a shared library a module needs but the loader can't find.
+type missingLibrary struct {
+	Module string // Module file name (e.g. "pg_kafka.so" or "pg_kafka.dylib")
+	Soname string
+}
+
+// missingLibraries checks the shared modules of an installed extension for
+// system libraries that are not installed.
+func missingLibraries(entry cellar.Entry) []missingLibrary {
+	var missing []missingLibrary
+	for _, module := range moduleFiles(entry) {
+		sonames, err := sharedlib.Missing(module)
+		if err != nil {
+			continue
+		}
+		for _, soname := range sonames {
+			missing = append(missing, missingLibrary{Module: filepath.Base(module), Soname: soname})
+		}
+	}
+	return missing
+}
+
+// moduleFiles returns the shared modules of an installed extension: its
+// files with PostgreSQL's module suffix (DLSUFFIX). Entries recorded before
+// file manifests existed fall back to <pkglibdir>/<name><suffix>.
+func moduleFiles(entry cellar.Entry) []string {
+	suffix := builder.ModuleSuffix(getPgConfigPath())
+	var modules []string
+	for _, f := range entry.Files {
+		if strings.HasSuffix(f, suffix) {
+			modules = append(modules, f)
+		}
+	}
+	if len(entry.Files) == 0 {
+		libDir := strings.TrimSpace(getCommandOutput(getPgConfigPath(), "--pkglibdir"))
+		module := filepath.Join(libDir, entry.Name+suffix)
+		if _, err := os.Stat(module); err == nil {
+			modules = append(modules, module)
+		}
+	}
+	return modules
+}
+
+// printMissingLibraries lists missing libraries with package hints.
+func printMissingLibraries(missing []missingLibrary, indent string) {
+	for _, m := range missing {
+		fmt.Printf("%s%s needs %s, which was not found\n", indent, m.Module, m.Soname)
+		fmt.Printf("%s  %s\n", indent, libraryInstallHint(m.Soname))
+	}
+}
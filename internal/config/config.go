@@ -0,0 +1,368 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Setting is the effective value of a configuration key.
+type Setting struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Source string `json:"source"` // "default", a config file path, or "env NAME"
+}
+
+// Scopes of the configuration files, from lowest to highest precedence
+const (
+	ScopeSystem  = "system"
+	ScopeUser    = "user"
+	ScopeProject = "project"
+)
+
+// keys are the known top-level settings with their defaults and the
+// environment variables that override them (first match wins).
+var keys = []struct {
+	Name    string
+	Default string
+	Env     []string
+	Help    string
+}{
+	{"pg_config", "pg_config", []string{"PGBREW_PG_CONFIG", "PG_CONFIG"}, "pg_config of the PostgreSQL to install into"},
+	{"sudo", "false", []string{"PGBREW_SUDO"}, "Use sudo for installation by default"},
+	{"cache_dir", "", []string{"PGBREW_CACHE_DIR"}, "Build and source cache directory"},
+	{"bottle_dir", "", []string{"PGBREW_BOTTLE_DIR"}, "Directory of prebuilt builds"},
+	{"offline", "false", []string{"PGBREW_OFFLINE"}, "Never access the network"},
+	{"rollback_generations", "3", []string{"PGBREW_ROLLBACK_GENERATIONS"}, "Previous installations kept per extension for pgx rollback"},
+	{"history_file", "", nil, "Install history file (default /var/log/pgbrew/history.jsonl for sudo or root, else ~/.local/state/pgbrew/history.jsonl; system configuration only)"},
+	{"pgxn_url", "https://api.pgxn.org", []string{"PGBREW_PGXN_URL"}, "PGXN API server used by pgx search"},
+	{"release_url", "https://github.com/matroidbe/pgbrew/releases", nil, "Release location used by pgx upgrade (a URL or a local directory; system configuration only)"},
+	{"release_public_key", "", nil, "Base64 ed25519 key release checksums must be signed with (default: built in; system configuration only)"},
+	{"output", "text", []string{"PGBREW_OUTPUT"}, "Output format of list, info and search: text or json"},
+}
+
+// systemOnly are the keys that decide which pgx binaries are trusted and
+// where the shared history is written (with sudo). They are only read from
+// the system configuration, so a user's or project's configuration, or the
+// environment, can't redirect upgrades, point sudo at another file or hide
+// changes from the history.
+var systemOnly = map[string]bool{
+	"release_url":        true,
+	"release_public_key": true,
+	"history_file":       true,
+}
+
+// notInProject are the keys and sections a project's .pgbrew.toml can't set:
+// they decide where sources are fetched from and which PostgreSQL pgx runs
+// and installs into, which a checked-out repository must not redirect.
+var notInProject = map[string]bool{
+	"pg_config": true,
+	"aliases":   true,
+	"mirrors":   true,
+}
+
+// Sections are tables of user-defined keys.
+var sections = map[string]string{
+	"aliases": "Short names for sources, e.g. aliases.vector = \"github.com/pgvector/pgvector\"",
+	"mirrors": "Git URL prefixes replacing source prefixes, e.g. mirrors.\"github.com\" = \"https://git.example.com/github\"",
+}
+
+// settings holds the effective configuration after Load
+var settings = map[string]Setting{}
+
+// Load reads the configuration layers: defaults, the system, user and
+// project files, then PGBREW_* environment variables.
+func Load() error {
+	settings = map[string]Setting{}
+	for _, k := range keys {
+		settings[k.Name] = Setting{Key: k.Name, Value: k.Default, Source: "default"}
+	}
+
+	for _, scope := range []string{ScopeSystem, ScopeUser, ScopeProject} {
+		path, err := Path(scope)
+		if err != nil {
+			continue
+		}
+		values, err := readFile(path)
+		if err != nil {
+			return err
+		}
+		for key, value := range values {
+			if err := validate(key, value); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			if err := checkScope(scope, key); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			settings[key] = Setting{Key: key, Value: value, Source: path}
+		}
+	}
+
+	for _, k := range keys {
+		for _, env := range k.Env {
+			if value := os.Getenv(env); value != "" {
+				settings[k.Name] = Setting{Key: k.Name, Value: value, Source: "env " + env}
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// Path returns the configuration file of a scope.
+func Path(scope string) (string, error) {
+	switch scope {
+	case ScopeSystem:
+		return "/etc/pgbrew/config.toml", nil
+	case ScopeUser:
+		if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+			return filepath.Join(xdg, "pgbrew", "config.toml"), nil
+		}
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("could not determine home directory: %w", err)
+		}
+		return filepath.Join(home, ".config", "pgbrew", "config.toml"), nil
+	case ScopeProject:
+		wd, err := os.Getwd()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(wd, ".pgbrew.toml"), nil
+	}
+	return "", fmt.Errorf("unknown scope %q (use %s, %s or %s)", scope, ScopeSystem, ScopeUser, ScopeProject)
+}
+
+// Get returns the effective setting for a key.
+func Get(key string) (Setting, bool) {
+	s, ok := settings[key]
+	return s, ok
+}
+
+// Override sets the effective value of a key for the rest of the run.
+func Override(key string, value string) {
+	settings[key] = Setting{Key: key, Value: value, Source: "command line"}
+}
+
+// String returns the effective value of a key ("" if unset).
+func String(key string) string {
+	return settings[key].Value
+}
+
+// Bool returns the effective value of a boolean key.
+func Bool(key string) bool {
+	b, _ := strconv.ParseBool(settings[key].Value)
+	return b
+}
+
+// Int returns the effective value of an integer key.
+func Int(key string) int {
+	n, _ := strconv.Atoi(settings[key].Value)
+	return n
+}
+
+// Section returns the keys of a section (e.g. "aliases") and their values.
+func Section(name string) map[string]string {
+	values := map[string]string{}
+	for key, s := range settings {
+		if sub, ok := strings.CutPrefix(key, name+"."); ok {
+			values[sub] = s.Value
+		}
+	}
+	return values
+}
+
+// All returns every effective setting, sorted by key.
+func All() []Setting {
+	all := make([]Setting, 0, len(settings))
+	for _, s := range settings {
+		all = append(all, s)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Key < all[j].Key })
+	return all
+}
+
+// Help describes the known keys and sections.
+func Help() [][2]string {
+	var help [][2]string
+	for _, k := range keys {
+		help = append(help, [2]string{k.Name, k.Help})
+	}
+	names := make([]string, 0, len(sections))
+	for name := range sections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		help = append(help, [2]string{name + ".<name>", sections[name]})
+	}
+	return help
+}
+
+// Set writes a key to the configuration file of a scope.
+func Set(scope string, key string, value string) error {
+	if err := validate(key, value); err != nil {
+		return err
+	}
+	if err := checkScope(scope, key); err != nil {
+		return err
+	}
+	return update(scope, func(doc map[string]any) {
+		if section, sub, ok := splitSectionKey(key); ok {
+			table, _ := doc[section].(map[string]any)
+			if table == nil {
+				table = map[string]any{}
+			}
+			table[sub] = value
+			doc[section] = table
+			return
+		}
+		doc[key] = typed(key, value)
+	})
+}
+
+// Unset removes a key from the configuration file of a scope.
+func Unset(scope string, key string) error {
+	return update(scope, func(doc map[string]any) {
+		if section, sub, ok := splitSectionKey(key); ok {
+			if table, _ := doc[section].(map[string]any); table != nil {
+				delete(table, sub)
+				if len(table) == 0 {
+					delete(doc, section)
+				}
+			}
+			return
+		}
+		delete(doc, key)
+	})
+}
+
+// update rewrites the configuration file of a scope.
+func update(scope string, change func(doc map[string]any)) error {
+	path, err := Path(scope)
+	if err != nil {
+		return err
+	}
+
+	doc := map[string]any{}
+	if _, err := os.Stat(path); err == nil {
+		if _, err := toml.DecodeFile(path, &doc); err != nil {
+			return fmt.Errorf("invalid %s: %w", path, err)
+		}
+	}
+	change(doc)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return toml.NewEncoder(f).Encode(doc)
+}
+
+// readFile reads a configuration file into flat keys ("aliases.vector").
+// A missing file has no values.
+func readFile(path string) (map[string]string, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	doc := map[string]any{}
+	if _, err := toml.DecodeFile(path, &doc); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+
+	values := map[string]string{}
+	for key, v := range doc {
+		if table, ok := v.(map[string]any); ok {
+			for sub, sv := range table {
+				values[key+"."+sub] = fmt.Sprint(sv)
+			}
+			continue
+		}
+		values[key] = fmt.Sprint(v)
+	}
+	return values, nil
+}
+
+// validate checks that a key is known and its value has the right type.
+func validate(key string, value string) error {
+	if section, _, ok := splitSectionKey(key); ok {
+		if _, known := sections[section]; known {
+			return nil
+		}
+	}
+	for _, k := range keys {
+		if k.Name != key {
+			continue
+		}
+		if k.Default == "true" || k.Default == "false" {
+			if _, err := strconv.ParseBool(value); err != nil {
+				return fmt.Errorf("%s must be true or false, got %q", key, value)
+			}
+		}
+		if _, err := strconv.Atoi(k.Default); err == nil {
+			if n, err := strconv.Atoi(value); err != nil || n < 0 {
+				return fmt.Errorf("%s must be a number, got %q", key, value)
+			}
+		}
+		if key == "output" && value != "text" && value != "json" {
+			return fmt.Errorf("output must be text or json, got %q", value)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown key %q (see 'pgx config list')", key)
+}
+
+// checkScope checks that a key may be set in the configuration file of a
+// scope.
+func checkScope(scope string, key string) error {
+	if systemOnly[key] && scope != ScopeSystem {
+		return fmt.Errorf("%s can only be set in the system configuration (pgx config set --scope %s)", key, ScopeSystem)
+	}
+	name := key
+	if section, _, ok := splitSectionKey(key); ok {
+		name = section
+	}
+	if notInProject[name] && scope == ScopeProject {
+		return fmt.Errorf("%s can't be set in a project's configuration, only for a user or the system", key)
+	}
+	return nil
+}
+
+// typed converts a value to the TOML type of its key.
+func typed(key string, value string) any {
+	for _, k := range keys {
+		if k.Name == key && (k.Default == "true" || k.Default == "false") {
+			b, _ := strconv.ParseBool(value)
+			return b
+		}
+		if k.Name == key {
+			if _, err := strconv.Atoi(k.Default); err == nil {
+				n, _ := strconv.Atoi(value)
+				return n
+			}
+		}
+	}
+	return value
+}
+
+// splitSectionKey splits "aliases.vector" into "aliases" and "vector".
+func splitSectionKey(key string) (section string, sub string, ok bool) {
+	section, sub, ok = strings.Cut(key, ".")
+	if !ok || sub == "" {
+		return "", "", false
+	}
+	if _, known := sections[section]; !known {
+		return "", "", false
+	}
+	return section, sub, true
+}
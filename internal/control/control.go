@@ -0,0 +1,201 @@
+package control
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Control is the metadata of an extension from its .control file.
+type Control struct {
+	Name           string   `json:"name"`
+	DefaultVersion string   `json:"default_version,omitempty"`
+	Comment        string   `json:"comment,omitempty"`
+	ModulePathname string   `json:"module_pathname,omitempty"`
+	Requires       []string `json:"requires,omitempty"`
+	Schema         string   `json:"schema,omitempty"`
+	Directory      string   `json:"directory,omitempty"`
+	Relocatable    bool     `json:"relocatable"`
+	Superuser      bool     `json:"superuser"` // Defaults to true
+	Trusted        bool     `json:"trusted"`
+
+	// Path is the .control file
+	Path string `json:"path"`
+}
+
+// Parse reads a .control file.
+func Parse(path string) (*Control, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	c := &Control{
+		Name:      strings.TrimSuffix(filepath.Base(path), ".control"),
+		Superuser: true,
+		Path:      path,
+	}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		// Skip comments and empty lines
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// Parse key = value
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		key = strings.TrimSpace(key)
+		value = strings.Trim(strings.TrimSpace(value), "'\"")
+
+		switch key {
+		case "default_version":
+			c.DefaultVersion = value
+		case "comment":
+			c.Comment = value
+		case "module_pathname":
+			c.ModulePathname = value
+		case "requires":
+			for _, r := range strings.Split(value, ",") {
+				if r = strings.TrimSpace(r); r != "" {
+					c.Requires = append(c.Requires, r)
+				}
+			}
+		case "schema":
+			c.Schema = value
+		case "directory":
+			c.Directory = value
+		case "relocatable":
+			c.Relocatable = parseBool(value)
+		case "superuser":
+			c.Superuser = parseBool(value)
+		case "trusted":
+			c.Trusted = parseBool(value)
+		}
+	}
+	return c, scanner.Err()
+}
+
+// List returns the extensions with a .control file in extDir, sorted by name.
+func List(extDir string) ([]Control, error) {
+	paths, err := filepath.Glob(filepath.Join(extDir, "*.control"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	var controls []Control
+	for _, path := range paths {
+		c, err := Parse(path)
+		if err != nil {
+			continue
+		}
+		controls = append(controls, *c)
+	}
+	return controls, nil
+}
+
+// Dependents returns the extensions in extDir that require name directly.
+func Dependents(extDir string, name string) ([]string, error) {
+	g, err := LoadGraph(extDir)
+	if err != nil {
+		return nil, err
+	}
+	return g.RequiredBy(name), nil
+}
+
+// ScriptDir returns the directory holding the extension's SQL scripts:
+// the directory setting (relative to shareDir), or the control file's own.
+func (c *Control) ScriptDir(shareDir string) string {
+	if c.Directory == "" {
+		return filepath.Dir(c.Path)
+	}
+	if filepath.IsAbs(c.Directory) {
+		return c.Directory
+	}
+	return filepath.Join(shareDir, c.Directory)
+}
+
+// Update is a script that updates an extension from one version to another.
+type Update struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Scripts returns the versions an extension can be created at and its update
+// paths, from the names of its SQL scripts (name--1.0.sql, name--1.0--1.1.sql).
+func Scripts(dir string, name string) (versions []string, updates []Update, err error) {
+	paths, err := filepath.Glob(filepath.Join(dir, name+"--*.sql"))
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		parts := strings.Split(strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), name+"--"), ".sql"), "--")
+		switch len(parts) {
+		case 1:
+			versions = append(versions, parts[0])
+		case 2:
+			updates = append(updates, Update{From: parts[0], To: parts[1]})
+		}
+	}
+	return versions, updates, nil
+}
+
+// libdirRef matches a shared library referenced from a control file or script
+var libdirRef = regexp.MustCompile(`\$libdir/([A-Za-z0-9_.+-]+)`)
+
+// Modules returns the shared libraries (without the module suffix, e.g.
+// ".so") that the extensions in extDir load, from their module_pathname and
+// their SQL scripts.
+func Modules(extDir string, suffix string) (map[string]bool, error) {
+	controls, err := List(extDir)
+	if err != nil {
+		return nil, err
+	}
+	modules := map[string]bool{}
+	for _, c := range controls {
+		if c.ModulePathname != "" {
+			modules[moduleName(c.ModulePathname, suffix)] = true
+		}
+	}
+
+	scripts, err := filepath.Glob(filepath.Join(extDir, "*.sql"))
+	if err != nil {
+		return nil, err
+	}
+	for _, script := range scripts {
+		data, err := os.ReadFile(script)
+		if err != nil {
+			continue
+		}
+		for _, m := range libdirRef.FindAllSubmatch(data, -1) {
+			modules[moduleName(string(m[1]), suffix)] = true
+		}
+	}
+	return modules, nil
+}
+
+// moduleName strips the directory and suffix from a library reference
+// ("$libdir/vector.so" is "vector" for ".so").
+func moduleName(ref string, suffix string) string {
+	return strings.TrimSuffix(filepath.Base(ref), suffix)
+}
+
+// parseBool reads a boolean setting the way PostgreSQL does.
+func parseBool(value string) bool {
+	switch strings.ToLower(value) {
+	case "true", "on", "yes", "1", "t", "y":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+package control
+
+import "sort"
+
+// Graph is the dependency graph of the extensions in an extension directory,
+// from the requires setting of their control files.
+type Graph struct {
+	requires map[string][]string
+}
+
+// LoadGraph reads the control files in extDir into a graph.
+func LoadGraph(extDir string) (*Graph, error) {
+	controls, err := List(extDir)
+	if err != nil {
+		return nil, err
+	}
+	g := &Graph{requires: map[string][]string{}}
+	for _, c := range controls {
+		g.requires[c.Name] = c.Requires
+	}
+	return g, nil
+}
+
+// Has reports whether an extension is installed.
+func (g *Graph) Has(name string) bool {
+	_, ok := g.requires[name]
+	return ok
+}
+
+// Requires returns the extensions an extension requires directly.
+func (g *Graph) Requires(name string) []string {
+	return g.requires[name]
+}
+
+// RequiredBy returns the installed extensions that require name directly,
+// sorted by name.
+func (g *Graph) RequiredBy(name string) []string {
+	var dependents []string
+	for ext, requires := range g.requires {
+		if ext == name {
+			continue
+		}
+		for _, r := range requires {
+			if r == name {
+				dependents = append(dependents, ext)
+				break
+			}
+		}
+	}
+	sort.Strings(dependents)
+	return dependents
+}
+
+// Deps returns everything an extension requires, directly or not, with each
+// extension after the ones it requires.
+func (g *Graph) Deps(name string) []string {
+	order := g.Order([]string{name})
+	return order[:len(order)-1]
+}
+
+// Dependents returns the installed extensions that require name, directly
+// or not, sorted by name.
+func (g *Graph) Dependents(name string) []string {
+	seen := map[string]bool{name: true}
+	queue := []string{name}
+	var dependents []string
+	for len(queue) > 0 {
+		for _, ext := range g.RequiredBy(queue[0]) {
+			if !seen[ext] {
+				seen[ext] = true
+				dependents = append(dependents, ext)
+				queue = append(queue, ext)
+			}
+		}
+		queue = queue[1:]
+	}
+	sort.Strings(dependents)
+	return dependents
+}
+
+// Order returns names with their requirements, each extension after the
+// ones it requires. Extensions that don't depend on each other keep their
+// order. Requirement cycles are broken where they are found.
+func (g *Graph) Order(names []string) []string {
+	var order []string
+	state := map[string]int{} // 1 visiting, 2 done
+	var visit func(name string)
+	visit = func(name string) {
+		if state[name] != 0 {
+			return
+		}
+		state[name] = 1
+		for _, r := range g.requires[name] {
+			visit(r)
+		}
+		state[name] = 2
+		order = append(order, name)
+	}
+	for _, name := range names {
+		visit(name)
+	}
+	return order
+}
+
+// Sort orders names so that each comes after the ones it requires, without
+// adding their requirements.
+func (g *Graph) Sort(names []string) []string {
+	wanted := map[string]bool{}
+	for _, name := range names {
+		wanted[name] = true
+	}
+	var sorted []string
+	for _, name := range g.Order(names) {
+		if wanted[name] {
+			sorted = append(sorted, name)
+			delete(wanted, name)
+		}
+	}
+	return sorted
+}
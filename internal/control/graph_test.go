@@ -0,0 +1,91 @@
+package control
+
+import (
+	"reflect"
+	"testing"
+)
+
+func testGraph() *Graph {
+	return &Graph{requires: map[string][]string{
+		// Chain
+		"postgis":        nil,
+		"postgis_raster": {"postgis"},
+		"postgis_tiger":  {"postgis_raster", "fuzzystrmatch"},
+		"fuzzystrmatch":  nil,
+		"standalone":     nil,
+		// Diamond
+		"base":  nil,
+		"left":  {"base"},
+		"right": {"base"},
+		"top":   {"left", "right"},
+		// Cycles
+		"ping": {"pong"},
+		"pong": {"ping"},
+		"self": {"self"},
+		// Requirement that isn't installed
+		"orphan": {"missing"},
+	}}
+}
+
+func TestOrder(t *testing.T) {
+	tests := []struct {
+		names []string
+		want  []string
+	}{
+		{[]string{"postgis_tiger"}, []string{"postgis", "postgis_raster", "fuzzystrmatch", "postgis_tiger"}},
+		{[]string{"standalone", "postgis"}, []string{"standalone", "postgis"}},
+		{[]string{"postgis_raster", "postgis"}, []string{"postgis", "postgis_raster"}},
+		{[]string{"top"}, []string{"base", "left", "right", "top"}},
+		{[]string{"ping"}, []string{"pong", "ping"}},
+		{[]string{"pong", "ping"}, []string{"ping", "pong"}},
+		{[]string{"self"}, []string{"self"}},
+		{[]string{"orphan"}, []string{"missing", "orphan"}},
+		{nil, nil},
+	}
+	g := testGraph()
+	for _, tt := range tests {
+		if got := g.Order(tt.names); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Order(%v) = %v, want %v", tt.names, got, tt.want)
+		}
+	}
+}
+
+func TestSort(t *testing.T) {
+	tests := []struct {
+		names []string
+		want  []string
+	}{
+		{[]string{"postgis_tiger", "postgis"}, []string{"postgis", "postgis_tiger"}},
+		{[]string{"top", "base", "standalone"}, []string{"base", "top", "standalone"}},
+		{[]string{"ping", "pong"}, []string{"pong", "ping"}},
+		{[]string{"self", "self"}, []string{"self"}},
+		{[]string{"orphan"}, []string{"orphan"}},
+	}
+	g := testGraph()
+	for _, tt := range tests {
+		if got := g.Sort(tt.names); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Sort(%v) = %v, want %v", tt.names, got, tt.want)
+		}
+	}
+}
+
+func TestDependents(t *testing.T) {
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"postgis", []string{"postgis_raster", "postgis_tiger"}},
+		{"fuzzystrmatch", []string{"postgis_tiger"}},
+		{"base", []string{"left", "right", "top"}},
+		{"top", nil},
+		{"ping", []string{"pong"}},
+		{"self", nil},
+		{"missing", []string{"orphan"}},
+	}
+	g := testGraph()
+	for _, tt := range tests {
+		if got := g.Dependents(tt.name); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Dependents(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
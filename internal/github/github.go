@@ -2,8 +2,14 @@ package github
 
 import (
 	"fmt"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+
+	"github.com/matroidbe/pgbrew/internal/buildlog"
+	"github.com/matroidbe/pgbrew/internal/cache"
+	"github.com/matroidbe/pgbrew/internal/offline"
 )
 
 // ParseURL parses a GitHub URL and returns the repository, optional subpath, and version.
@@ -44,32 +50,233 @@ func ParseURL(url string) (repo string, subpath string, version string, err erro
 	return repo, subpath, version, nil
 }
 
-// Clone clones a GitHub repository to the specified directory.
-// If ref is provided (tag, branch, or commit), it checks out that ref.
-func Clone(repo string, dir string, ref string) error {
-	url := "https://" + repo + ".git"
+// mirrors maps source prefixes (e.g. "github.com/org") to the git URL
+// prefixes to fetch them from instead
+var mirrors map[string]string
+
+// SetMirrors sets the git URL prefixes that replace source prefixes.
+func SetMirrors(m map[string]string) {
+	mirrors = m
+}
+
+// RemoteURL returns the git URL to fetch repo from: the longest matching
+// mirror prefix, or GitHub itself.
+func RemoteURL(repo string) string {
+	best := ""
+	for prefix := range mirrors {
+		if (repo == prefix || strings.HasPrefix(repo, prefix+"/")) && len(prefix) > len(best) {
+			best = prefix
+		}
+	}
+	if best == "" {
+		return "https://" + repo + ".git"
+	}
+	return strings.TrimSuffix(mirrors[best], "/") + strings.TrimPrefix(repo, best) + ".git"
+}
+
+// MirrorDir returns the directory holding the bare mirror of repo.
+func MirrorDir(repo string) (string, error) {
+	dir, err := cache.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "git", filepath.FromSlash(repo)+".git"), nil
+}
+
+// UpdateMirror creates the bare mirror of repo if needed and fetches new
+// branches and tags into it. Subsequent updates only transfer new objects.
+// In offline mode the existing mirror is used as-is. Git output goes to log.
+func UpdateMirror(repo string, log *buildlog.Log) (string, error) {
+	dir, err := MirrorDir(repo)
+	if err != nil {
+		return "", err
+	}
+
+	_, statErr := os.Stat(filepath.Join(dir, "HEAD"))
+	if offline.Enabled() {
+		if statErr != nil {
+			return "", offline.Missing("git mirror of "+repo,
+				fmt.Sprintf("Run 'pgx fetch %s' on a connected machine and copy %s to this host.", repo, dir))
+		}
+		return dir, nil
+	}
+
+	if os.IsNotExist(statErr) {
+		if err := initMirror(repo, dir, log); err != nil {
+			os.RemoveAll(dir)
+			return "", err
+		}
+	}
+
+	// Follow changes to the configured mirrors
+	if err := git(log, "-C", dir, "remote", "set-url", "origin", RemoteURL(repo)); err != nil {
+		return "", err
+	}
+
+	cmd := exec.Command("git", "-C", dir, "fetch", "--prune", "--quiet", "origin")
+	if err := log.Run("Fetching "+repo, cmd); err != nil {
+		return "", fmt.Errorf("git fetch failed: %w", err)
+	}
+	return dir, nil
+}
+
+// initMirror sets up an empty bare repository that fetches branches, tags
+// and the remote's default branch. Pull request refs are deliberately left
+// out, since they can be very large on popular repositories.
+func initMirror(repo, dir string, log *buildlog.Log) error {
+	url := RemoteURL(repo)
+	steps := [][]string{
+		{"init", "--quiet", "--bare", dir},
+		{"-C", dir, "remote", "add", "origin", url},
+		{"-C", dir, "config", "--replace-all", "remote.origin.fetch", "+refs/heads/*:refs/heads/*"},
+		{"-C", dir, "config", "--add", "remote.origin.fetch", "+refs/tags/*:refs/tags/*"},
+		{"-C", dir, "config", "--add", "remote.origin.fetch", "+HEAD:" + defaultBranchRef},
+	}
+	for _, args := range steps {
+		if err := git(log, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// defaultBranchRef is where the mirror records the remote's default branch.
+const defaultBranchRef = "refs/remotes/origin/HEAD"
 
+// ResolveRef returns the commit SHA that ref (tag, branch, or commit) points
+// to in the mirror. An empty ref resolves the remote's default branch.
+func ResolveRef(mirror string, ref string) (string, error) {
 	if ref == "" {
-		// Simple shallow clone of default branch
-		cmd := exec.Command("git", "clone", "--depth", "1", url, dir)
-		output, err := cmd.CombinedOutput()
-		if err != nil {
-			return fmt.Errorf("git clone failed: %s\n%s", err, string(output))
+		ref = defaultBranchRef
+	}
+	cmd := exec.Command("git", "-C", mirror, "rev-parse", "--verify", "--quiet", ref+"^{commit}")
+	output, err := cmd.Output()
+	if err != nil {
+		if offline.Enabled() {
+			return "", offline.Missing(fmt.Sprintf("ref %q in the local mirror %s", ref, mirror),
+				"Run 'pgx fetch' for this ref on a connected machine and copy the mirror to this host.")
 		}
-	} else {
-		// Clone with specific ref - need full clone for tags/commits
-		cmd := exec.Command("git", "clone", url, dir)
-		output, err := cmd.CombinedOutput()
-		if err != nil {
-			return fmt.Errorf("git clone failed: %s\n%s", err, string(output))
+		return "", fmt.Errorf("unknown ref %q", ref)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// AddWorktree checks out commit from the mirror into dir. If subpath is set,
+// sparse checkout limits the files materialised to that directory (plus the
+// files at the repository root, such as a Cargo workspace manifest).
+func AddWorktree(mirror string, commit string, dir string, subpath string, log *buildlog.Log) error {
+	if err := git(log, "--git-dir="+mirror, "worktree", "add", "--quiet", "--detach", "--no-checkout", dir, commit); err != nil {
+		return err
+	}
+
+	if subpath != "" {
+		if err := git(log, "-C", dir, "sparse-checkout", "set", "--cone", subpath); err != nil {
+			return err
 		}
+	}
 
-		// Checkout the specific ref
-		cmd = exec.Command("git", "-C", dir, "checkout", ref)
-		output, err = cmd.CombinedOutput()
-		if err != nil {
-			return fmt.Errorf("git checkout %s failed: %s\n%s", ref, err, string(output))
+	return git(log, "-C", dir, "checkout", "--quiet")
+}
+
+// ExpandSparsePath makes sure subpath is materialised in an existing
+// worktree. Full checkouts already contain everything and are left alone;
+// an empty subpath turns a sparse worktree into a full one.
+func ExpandSparsePath(dir string, subpath string, log *buildlog.Log) error {
+	output, err := exec.Command("git", "-C", dir, "config", "--get", "core.sparseCheckout").Output()
+	if err != nil || strings.TrimSpace(string(output)) != "true" {
+		return nil
+	}
+
+	args := []string{"-C", dir, "sparse-checkout", "add", subpath}
+	if subpath == "" {
+		args = []string{"-C", dir, "sparse-checkout", "disable"}
+	}
+	return git(log, args...)
+}
+
+// git runs a git command that is expected to be quiet. Its output goes to
+// log; without a log it is included in the returned error instead.
+func git(log *buildlog.Log, args ...string) error {
+	cmd := exec.Command("git", args...)
+	name := "git " + gitSubcommand(args)
+
+	if log == nil {
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("%s failed: %s\n%s", name, err, string(output))
+		}
+		return nil
+	}
+
+	log.Printf("$ git %s\n", strings.Join(args, " "))
+	cmd.Stdout = log.Writer()
+	cmd.Stderr = log.Writer()
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s failed: %w (see %s)", name, err, log.Path())
+	}
+	return nil
+}
+
+// gitSubcommand returns the git subcommand in args, skipping global options.
+func gitSubcommand(args []string) string {
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "-C":
+			i++
+		case strings.HasPrefix(args[i], "-"):
+		default:
+			return args[i]
+		}
+	}
+	return ""
+}
+
+// RemoveWorktree deletes a worktree and unregisters it from the mirror.
+func RemoveWorktree(mirror string, dir string) error {
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	return exec.Command("git", "--git-dir="+mirror, "worktree", "prune").Run()
+}
+
+// PruneWorktrees unregisters worktrees whose directories no longer exist
+// from every mirror in the cache.
+func PruneWorktrees() error {
+	dir, err := cache.Dir()
+	if err != nil {
+		return err
+	}
+	mirrors, err := Mirrors()
+	if err != nil {
+		return err
+	}
+	for _, m := range mirrors {
+		cmd := exec.Command("git", "--git-dir="+filepath.Join(dir, "git", m), "worktree", "prune")
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("git worktree prune failed for %s: %s\n%s", m, err, string(output))
 		}
 	}
 	return nil
 }
+
+// Mirrors returns the mirrors in the cache, relative to the mirror root
+// (e.g. "github.com/user/repo.git").
+func Mirrors() ([]string, error) {
+	dir, err := cache.Dir()
+	if err != nil {
+		return nil, err
+	}
+	root := filepath.Join(dir, "git")
+	matches, err := filepath.Glob(filepath.Join(root, "*", "*", "*.git"))
+	if err != nil {
+		return nil, err
+	}
+	mirrors := make([]string, 0, len(matches))
+	for _, m := range matches {
+		rel, err := filepath.Rel(root, m)
+		if err != nil {
+			continue
+		}
+		mirrors = append(mirrors, filepath.ToSlash(rel))
+	}
+	return mirrors, nil
+}
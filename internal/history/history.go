@@ -0,0 +1,191 @@
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Record is one change to the installed extensions.
+type Record struct {
+	Time          time.Time `json:"time"`
+	User          string    `json:"user"` // Includes the invoking user under sudo ("root (sudo alice)")
+	Host          string    `json:"host"`
+	Command       string    `json:"command"` // Command line as typed
+	Action        string    `json:"action"`  // install, uninstall, link, unlink, switch, rollback, test
+	Extension     string    `json:"extension"`
+	Source        string    `json:"source,omitempty"`
+	Commit        string    `json:"commit,omitempty"`
+	VersionBefore string    `json:"version_before,omitempty"`
+	VersionAfter  string    `json:"version_after,omitempty"`
+	PgConfig      string    `json:"pg_config"`
+	PgVersion     string    `json:"pg_version,omitempty"`
+	Sudo          bool      `json:"sudo"`
+	Outcome       string    `json:"outcome"` // "success" or "failure"
+	Error         string    `json:"error,omitempty"`
+}
+
+// Outcomes of a change
+const (
+	Success = "success"
+	Failure = "failure"
+)
+
+// SystemPath is the default history file for changes to a system
+// PostgreSQL: those made with sudo, or by root.
+const SystemPath = "/var/log/pgbrew/history.jsonl"
+
+// path overrides the history file (from the history_file setting)
+var path string
+
+// SetPath sets the history file ("" for the default).
+func SetPath(p string) {
+	path = p
+}
+
+// Path returns the history file. Unless history_file is set, changes to a
+// system PostgreSQL go to SystemPath, so every administrator's changes end
+// up in one place, and other changes to
+// $XDG_STATE_HOME/pgbrew/history.jsonl (~/.local/state/pgbrew/history.jsonl).
+func Path(system bool) (string, error) {
+	if path != "" {
+		return path, nil
+	}
+	if system {
+		return SystemPath, nil
+	}
+	state := os.Getenv("XDG_STATE_HOME")
+	if state == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("could not determine home directory: %w", err)
+		}
+		state = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(state, "pgbrew", "history.jsonl"), nil
+}
+
+// Append adds a record to the history, filling in the time, user and host.
+// The file is only ever appended to; for changes made with sudo, it is
+// written with sudo too.
+func Append(r Record) error {
+	system := r.Sudo || os.Geteuid() == 0
+	p, err := Path(system)
+	if err != nil {
+		return err
+	}
+
+	r.Time = time.Now()
+	r.User = currentUser()
+	r.Host, _ = os.Hostname()
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if r.Sudo && os.Geteuid() != 0 {
+		return appendWithSudo(p, data)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(p, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// appendWithSudo appends data to the file at p as root.
+func appendWithSudo(p string, data []byte) error {
+	if output, err := exec.Command("sudo", "mkdir", "-p", filepath.Dir(p)).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to create %s: %s", filepath.Dir(p), strings.TrimSpace(string(output)))
+	}
+	cmd := exec.Command("sudo", "tee", "-a", p)
+	cmd.Stdin = bytes.NewReader(data)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to write %s with sudo: %s", p, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// Read returns the records of an extension (all records if name is empty),
+// oldest first. Unless history_file is set, both the user's and the system
+// history are read.
+func Read(name string) ([]Record, error) {
+	var files []string
+	for _, system := range []bool{false, true} {
+		p, err := Path(system)
+		if err != nil {
+			return nil, err
+		}
+		if len(files) == 0 || files[0] != p {
+			files = append(files, p)
+		}
+	}
+
+	var records []Record
+	for _, p := range files {
+		r, err := readFile(p, name)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, r...)
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Time.Before(records[j].Time) })
+	return records, nil
+}
+
+func readFile(p string, name string) ([]Record, error) {
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", p, line, err)
+		}
+		if name == "" || r.Extension == name {
+			records = append(records, r)
+		}
+	}
+	return records, scanner.Err()
+}
+
+// currentUser names the user running pgx, and who invoked sudo if it did.
+func currentUser() string {
+	name := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	if sudoUser := os.Getenv("SUDO_USER"); sudoUser != "" && sudoUser != name {
+		name += " (sudo " + sudoUser + ")"
+	}
+	return name
+}
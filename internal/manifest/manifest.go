@@ -0,0 +1,169 @@
+package manifest
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/matroidbe/pgbrew/internal/buildlog"
+)
+
+// FileName is the manifest extension authors commit to their repository.
+const FileName = "pgbrew.toml"
+
+// Manifest declares what an extension needs to be built and installed.
+// Every field is optional; unset fields fall back to detection.
+//
+// Example:
+//
+//	build_system = "pgrx"
+//	subpath = "extensions/pg_kafka"
+//	features = ["icu"]
+//	shared_preload = true
+//
+//	[postgres]
+//	min = 14
+//	max = 17
+//
+//	[system_deps]
+//	debian = ["librdkafka-dev"]
+//	redhat = ["librdkafka-devel"]
+//
+//	[hooks]
+//	pre_install = ["./scripts/setup-venv.sh"]
+//	post_install = ["ldconfig"]
+type Manifest struct {
+	BuildSystem   string              `toml:"build_system"`   // "pgrx" or "pgxs"
+	UseMakefile   *bool               `toml:"use_makefile"`   // pgrx: build with the project's Makefile install target
+	Subpath       string              `toml:"subpath"`        // Extension directory, relative to the manifest
+	Features      []string            `toml:"features"`       // Cargo features for pgrx builds
+	SharedPreload *bool               `toml:"shared_preload"` // Must be in shared_preload_libraries
+	Postgres      Postgres            `toml:"postgres"`
+	SystemDeps    map[string][]string `toml:"system_deps"` // Packages per distribution family
+	Hooks         Hooks               `toml:"hooks"`
+
+	// Dir is the directory containing the manifest
+	Dir string `toml:"-"`
+}
+
+// Postgres is the range of supported PostgreSQL major versions (0 = no limit).
+type Postgres struct {
+	Min int `toml:"min"`
+	Max int `toml:"max"`
+}
+
+// Hooks are shell commands run around the build.
+type Hooks struct {
+	PreInstall  []string `toml:"pre_install"`  // Run in the source directory before building
+	PostInstall []string `toml:"post_install"` // Run after the files are installed
+}
+
+// Load reads the manifest in dir. It returns nil if there is none.
+func Load(dir string) (*Manifest, error) {
+	path := filepath.Join(dir, FileName)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	var m Manifest
+	md, err := toml.DecodeFile(path, &m)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		return nil, fmt.Errorf("invalid %s: unknown key %q", path, undecoded[0].String())
+	}
+	switch m.BuildSystem {
+	case "", "pgrx", "pgxs":
+	default:
+		return nil, fmt.Errorf("invalid %s: unknown build_system %q", path, m.BuildSystem)
+	}
+	if m.Postgres.Min > 0 && m.Postgres.Max > 0 && m.Postgres.Min > m.Postgres.Max {
+		return nil, fmt.Errorf("invalid %s: postgres.min is greater than postgres.max", path)
+	}
+
+	m.Dir = dir
+	return &m, nil
+}
+
+// Find returns the manifest for the extension at subpath of a source tree:
+// one in the extension directory, or else one at the root that points to
+// this extension with its subpath.
+func Find(root string, subpath string) (*Manifest, error) {
+	if subpath != "" {
+		m, err := Load(filepath.Join(root, subpath))
+		if m != nil || err != nil {
+			return m, err
+		}
+	}
+
+	m, err := Load(root)
+	if err != nil || m == nil {
+		return nil, err
+	}
+	if filepath.Clean(m.Subpath) != filepath.Clean(subpath) {
+		return nil, nil
+	}
+	return m, nil
+}
+
+// SupportsPostgres reports whether the extension supports a PostgreSQL
+// major version.
+func (m *Manifest) SupportsPostgres(major int) bool {
+	if m.Postgres.Min > 0 && major < m.Postgres.Min {
+		return false
+	}
+	if m.Postgres.Max > 0 && major > m.Postgres.Max {
+		return false
+	}
+	return true
+}
+
+// PostgresRange describes the supported PostgreSQL versions.
+func (m *Manifest) PostgresRange() string {
+	switch {
+	case m.Postgres.Min > 0 && m.Postgres.Max > 0:
+		return fmt.Sprintf("PostgreSQL %d to %d", m.Postgres.Min, m.Postgres.Max)
+	case m.Postgres.Min > 0:
+		return fmt.Sprintf("PostgreSQL %d or newer", m.Postgres.Min)
+	case m.Postgres.Max > 0:
+		return fmt.Sprintf("PostgreSQL %d or older", m.Postgres.Max)
+	}
+	return "any PostgreSQL version"
+}
+
+// BuildSettings describes the manifest settings that change what a build
+// produces, for the build cache key.
+func (m *Manifest) BuildSettings() string {
+	if m == nil {
+		return ""
+	}
+	var parts []string
+	if m.BuildSystem != "" {
+		parts = append(parts, "build_system="+m.BuildSystem)
+	}
+	if m.UseMakefile != nil {
+		parts = append(parts, fmt.Sprintf("use_makefile=%t", *m.UseMakefile))
+	}
+	if len(m.Features) > 0 {
+		parts = append(parts, "features="+strings.Join(m.Features, ","))
+	}
+	return strings.Join(parts, " ")
+}
+
+// RunHooks runs hook commands with sh in dir. env is added to the
+// environment; output goes to log.
+func RunHooks(name string, commands []string, dir string, env []string, log *buildlog.Log) error {
+	for _, command := range commands {
+		cmd := exec.Command("sh", "-c", command)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), env...)
+		if err := log.Run(fmt.Sprintf("Running %s hook: %s", name, command), cmd); err != nil {
+			return fmt.Errorf("%s hook %q failed: %w", name, command, err)
+		}
+	}
+	return nil
+}
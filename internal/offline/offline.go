@@ -0,0 +1,24 @@
+package offline
+
+import (
+	"fmt"
+)
+
+// enabled controls whether pgbrew may access the network
+var enabled bool
+
+// SetEnabled sets whether offline mode is active
+func SetEnabled(offline bool) {
+	enabled = offline
+}
+
+// Enabled reports whether offline mode is active.
+func Enabled() bool {
+	return enabled
+}
+
+// Missing returns the error reported when offline mode prevents fetching an
+// artifact. The hint tells the user how to make the artifact available.
+func Missing(artifact string, hint string) error {
+	return fmt.Errorf("offline mode: %s is not available locally\n  %s", artifact, hint)
+}
@@ -0,0 +1,192 @@
+package pgcluster
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/matroidbe/pgbrew/internal/buildlog"
+)
+
+// superuser is the bootstrap superuser of throwaway clusters
+const superuser = "postgres"
+
+// Cluster is a throwaway PostgreSQL cluster, reachable only through a unix
+// socket in its own directory.
+type Cluster struct {
+	BinDir    string // PostgreSQL server binaries
+	Dir       string // Root directory holding data, socket and log
+	DataDir   string
+	SocketDir string
+	Port      int
+	LogFile   string // Server log
+}
+
+// Options configures a throwaway cluster.
+type Options struct {
+	Settings map[string]string // Extra postgresql.conf settings
+	Log      *buildlog.Log     // Captures initdb/pg_ctl output
+}
+
+// Start creates and starts a throwaway cluster using the server binaries of
+// the installation described by pgConfig. Call Stop to shut it down and
+// delete it.
+func Start(pgConfig string, opts Options) (*Cluster, error) {
+	if err := CheckUser(); err != nil {
+		return nil, err
+	}
+
+	output, err := exec.Command(pgConfig, "--bindir").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get bindir from pg_config: %w", err)
+	}
+	binDir := strings.TrimSpace(string(output))
+	for _, tool := range []string{"initdb", "pg_ctl", "psql"} {
+		if _, err := os.Stat(filepath.Join(binDir, tool)); err != nil {
+			return nil, fmt.Errorf("%s not found in %s (are the PostgreSQL server binaries installed?)", tool, binDir)
+		}
+	}
+
+	dir, err := os.MkdirTemp("", "pgbrew-cluster-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cluster directory: %w", err)
+	}
+
+	port, err := freePort()
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	c := &Cluster{
+		BinDir:    binDir,
+		Dir:       dir,
+		DataDir:   filepath.Join(dir, "data"),
+		SocketDir: dir,
+		Port:      port,
+		LogFile:   filepath.Join(dir, "server.log"),
+	}
+
+	initdb := exec.Command(filepath.Join(binDir, "initdb"), "-D", c.DataDir, "-U", superuser, "-A", "trust", "-E", "UTF8", "--no-sync")
+	if err := opts.Log.Run("Creating test cluster", initdb); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("initdb failed: %w", err)
+	}
+
+	settings := map[string]string{
+		"listen_addresses":        "''",
+		"unix_socket_directories": "'" + c.SocketDir + "'",
+		"port":                    fmt.Sprint(c.Port),
+		"fsync":                   "off",
+	}
+	for k, v := range opts.Settings {
+		settings[k] = v
+	}
+	if err := appendSettings(filepath.Join(c.DataDir, "postgresql.conf"), settings); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	pgCtl := exec.Command(filepath.Join(binDir, "pg_ctl"), "-D", c.DataDir, "-l", c.LogFile, "-w", "start")
+	if err := opts.Log.Run("Starting test server", pgCtl); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to start test server: %w\n%s", err, c.ServerLog(20))
+	}
+
+	return c, nil
+}
+
+// CheckUser returns an error if the current user cannot run a PostgreSQL
+// server, which refuses to start as root.
+func CheckUser() error {
+	if os.Geteuid() == 0 {
+		return fmt.Errorf("PostgreSQL cannot run as root; run this command as an unprivileged user (with --sudo if needed)")
+	}
+	return nil
+}
+
+// Stop shuts the server down and deletes the cluster.
+func (c *Cluster) Stop() error {
+	cmd := exec.Command(filepath.Join(c.BinDir, "pg_ctl"), "-D", c.DataDir, "-m", "immediate", "-w", "stop")
+	err := cmd.Run()
+	os.RemoveAll(c.Dir)
+	return err
+}
+
+// Env returns libpq environment variables pointing at the cluster.
+func (c *Cluster) Env() []string {
+	return []string{
+		"PGHOST=" + c.SocketDir,
+		fmt.Sprintf("PGPORT=%d", c.Port),
+		"PGUSER=" + superuser,
+		"PGDATABASE=postgres",
+	}
+}
+
+// Psql runs a SQL command in a database of the cluster and returns its
+// unaligned, tuples-only output.
+func (c *Cluster) Psql(db string, sql string) (string, error) {
+	output, err := c.PsqlCommand(db, sql).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s", strings.TrimSpace(string(output)))
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// PsqlCommand returns a psql command that runs sql in a database of the cluster.
+func (c *Cluster) PsqlCommand(db string, sql string) *exec.Cmd {
+	return exec.Command(filepath.Join(c.BinDir, "psql"), "-X", "-q", "-t", "-A", "-v", "ON_ERROR_STOP=1",
+		"-h", c.SocketDir, "-p", fmt.Sprint(c.Port), "-U", superuser, "-d", db, "-c", sql)
+}
+
+// ServerLog returns the last lines of the server log.
+func (c *Cluster) ServerLog(lines int) string {
+	data, err := os.ReadFile(c.LogFile)
+	if err != nil {
+		return ""
+	}
+	all := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if len(all) > lines {
+		all = all[len(all)-lines:]
+	}
+	return strings.Join(all, "\n")
+}
+
+// freePort returns a TCP port that is currently unused. The server only
+// listens on a unix socket, but the port number names the socket file.
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, fmt.Errorf("failed to find a free port: %w", err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// appendSettings appends settings to a postgresql.conf file; later entries
+// override earlier ones.
+func appendSettings(path string, settings map[string]string) error {
+	keys := make([]string, 0, len(settings))
+	for k := range settings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString("\n# Added by pgbrew\n")
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s = %s\n", k, settings[k])
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(b.String())
+	return err
+}
@@ -0,0 +1,173 @@
+package pgcluster
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Prefix is a throwaway copy of a PostgreSQL installation with a staged
+// build layered over it, so an extension can be tested without installing
+// it into the real installation.
+//
+// It mirrors the installation's absolute paths under Dir, like a DESTDIR
+// tree. The server binaries are copied, since PostgreSQL finds its library
+// and share directories relative to its own executable; everything else is
+// symlinked file by file, so anything installed into the prefix lands in the
+// prefix.
+type Prefix struct {
+	Dir    string
+	BinDir string
+}
+
+// NewPrefix creates a prefix for the installation described by pgConfig,
+// with the files of tree (a staged install tree, or "" for none) layered
+// over it. The installation's own files of extension ext are left out, so
+// only the staged version is visible.
+func NewPrefix(pgConfig string, tree string, ext string) (*Prefix, error) {
+	dirs := map[string]string{}
+	for _, flag := range []string{"--bindir", "--pkglibdir", "--sharedir", "--pkgincludedir", "--includedir-server"} {
+		output, err := exec.Command(pgConfig, flag).Output()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %s from pg_config: %w", flag, err)
+		}
+		dirs[flag] = strings.TrimSpace(string(output))
+	}
+
+	dir, err := os.MkdirTemp("", "pgbrew-prefix-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create test installation: %w", err)
+	}
+	p := &Prefix{Dir: dir, BinDir: filepath.Join(dir, dirs["--bindir"])}
+
+	if tree != "" {
+		if err := mirror(tree, dir, nil); err != nil {
+			p.Remove()
+			return nil, fmt.Errorf("failed to stage build for testing: %w", err)
+		}
+	}
+	if err := copyBinaries(dirs["--bindir"], p.BinDir); err != nil {
+		p.Remove()
+		return nil, fmt.Errorf("failed to copy PostgreSQL binaries: %w", err)
+	}
+
+	libDir, extDir := dirs["--pkglibdir"], filepath.Join(dirs["--sharedir"], "extension")
+	skip := func(path string) bool {
+		name := filepath.Base(path)
+		switch filepath.Dir(path) {
+		case libDir:
+			return strings.TrimSuffix(name, filepath.Ext(name)) == ext
+		case filepath.Join(libDir, "bitcode"):
+			return name == ext || name == ext+".index.bc"
+		case extDir:
+			return name == ext+".control" || strings.HasPrefix(name, ext+"--")
+		}
+		return false
+	}
+	for _, flag := range []string{"--pkglibdir", "--sharedir", "--pkgincludedir", "--includedir-server"} {
+		live := dirs[flag]
+		if live == "" {
+			continue
+		}
+		if err := mirror(live, filepath.Join(dir, live), skip); err != nil {
+			p.Remove()
+			return nil, fmt.Errorf("failed to mirror %s: %w", live, err)
+		}
+	}
+	return p, nil
+}
+
+// PgConfig returns the prefix's own pg_config, which reports the prefix's
+// directories.
+func (p *Prefix) PgConfig() string {
+	return filepath.Join(p.BinDir, "pg_config")
+}
+
+// Remove deletes the prefix.
+func (p *Prefix) Remove() error {
+	return os.RemoveAll(p.Dir)
+}
+
+// mirror recreates the directories under src in dst and symlinks each file
+// that dst doesn't have yet, except those skip matches.
+func mirror(src, dst string, skip func(string) bool) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == src {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if skip != nil && path != src && skip(path) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if _, err := os.Lstat(target); err == nil {
+			return nil
+		}
+		return os.Symlink(path, target)
+	})
+}
+
+// copyBinaries copies the executables of bindir into dst.
+func copyBinaries(bindir, dst string) error {
+	entries, err := os.ReadDir(bindir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		path := filepath.Join(bindir, entry.Name())
+		target := filepath.Join(dst, entry.Name())
+		if entry.Type()&os.ModeSymlink != 0 {
+			// e.g. postmaster -> postgres
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(link, target); err != nil {
+				return err
+			}
+			continue
+		}
+		if entry.IsDir() {
+			continue
+		}
+		if err := copyExecutable(path, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyExecutable(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -2,58 +2,81 @@ package pgrx
 
 import (
 	"fmt"
+	"io/fs"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
+
+	"github.com/matroidbe/pgbrew/internal/buildlog"
+	"github.com/matroidbe/pgbrew/internal/offline"
 )
 
-// NeedsSharedPreload checks if the extension uses background workers
-// which require shared_preload_libraries configuration.
+// NeedsSharedPreload checks the extension's _PG_init for work that only
+// succeeds at server start: registering a static background worker
+// (BackgroundWorkerBuilder ... .load(), as opposed to load_dynamic()) or
+// setting up shared memory with pg_shmem_init!.
 func NeedsSharedPreload(dir string) bool {
-	return checkDirForBgWorker(filepath.Join(dir, "src"))
-}
-
-func checkDirForBgWorker(dir string) bool {
-	entries, err := os.ReadDir(dir)
-	if err != nil {
+	body := findPgInit(filepath.Join(dir, "src"))
+	if body == "" {
 		return false
 	}
+	if strings.Contains(body, "pg_shmem_init!") {
+		return true
+	}
+	return strings.Contains(body, "BackgroundWorkerBuilder") && staticLoad.MatchString(body)
+}
 
-	for _, entry := range entries {
-		fullPath := filepath.Join(dir, entry.Name())
+// staticLoad matches BackgroundWorkerBuilder::load(), which registers a
+// worker that is started with the server
+var staticLoad = regexp.MustCompile(`\.load\(\s*\)`)
 
-		if entry.IsDir() {
-			// Check for parallel_worker or bgworker directory names
-			name := strings.ToLower(entry.Name())
-			if strings.Contains(name, "worker") || strings.Contains(name, "bgw") {
-				return true
-			}
-			// Recurse into subdirectories
-			if checkDirForBgWorker(fullPath) {
-				return true
-			}
-		} else if strings.HasSuffix(entry.Name(), ".rs") {
-			content, err := os.ReadFile(fullPath)
-			if err != nil {
-				continue
-			}
+// pgInitFn matches the start of the _PG_init definition
+var pgInitFn = regexp.MustCompile(`fn\s+_PG_init\s*\(`)
 
-			// Look for background worker indicators in pgrx
-			contentStr := string(content)
-			if strings.Contains(contentStr, "BackgroundWorker") ||
-				strings.Contains(contentStr, "bgworker") ||
-				strings.Contains(contentStr, "RegisterDynamicBackgroundWorker") ||
-				strings.Contains(contentStr, "SharedMemoryInit") ||
-				strings.Contains(contentStr, "max_parallel_workers") ||
-				strings.Contains(contentStr, "ParallelWorker") {
-				return true
+// findPgInit returns the body of the _PG_init function in the Rust sources
+// under dir ("" if not found).
+func findPgInit(dir string) string {
+	var body string
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || filepath.Ext(path) != ".rs" {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil
+		}
+		loc := pgInitFn.FindIndex(data)
+		if loc == nil {
+			return nil
+		}
+		body = braceBlock(string(data[loc[1]:]))
+		return filepath.SkipAll
+	})
+	return body
+}
+
+// braceBlock returns the first {...} block in s, with nested braces.
+func braceBlock(s string) string {
+	start := strings.Index(s, "{")
+	if start == -1 {
+		return ""
+	}
+	depth := 0
+	for i := start; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return s[start : i+1]
 			}
 		}
 	}
-
-	return false
+	return s[start:]
 }
 
 // IsProject checks if the directory contains a pgrx-based Cargo project.
@@ -179,7 +202,8 @@ func GetInstalledPgrxVersion() (string, error) {
 }
 
 // EnsurePgrxVersion installs the required cargo-pgrx version if needed.
-func EnsurePgrxVersion(requiredVersion string) error {
+// Output of cargo install goes to log (a nil log streams it).
+func EnsurePgrxVersion(requiredVersion string, log *buildlog.Log) error {
 	installed, err := GetInstalledPgrxVersion()
 
 	// Check if installed version matches required (including partial version matches)
@@ -210,18 +234,68 @@ func EnsurePgrxVersion(requiredVersion string) error {
 		installVersion = "^" + requiredVersion
 	}
 
-	fmt.Printf("Installing cargo-pgrx %s (current: %s)...\n", requiredVersion, installed)
+	if offline.Enabled() {
+		current := installed
+		if current == "" {
+			current = "none"
+		}
+		return offline.Missing(fmt.Sprintf("cargo-pgrx %s (installed: %s)", requiredVersion, current),
+			fmt.Sprintf("Install it with 'cargo install cargo-pgrx --version %s --locked' before going offline.", installVersion))
+	}
+
+	step := fmt.Sprintf("Installing cargo-pgrx %s (current: %s)", requiredVersion, installed)
 	cmd := exec.Command("cargo", "install", "cargo-pgrx", "--version", installVersion, "--locked")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
 
-	if err := cmd.Run(); err != nil {
+	if err := log.Run(step, cmd); err != nil {
 		return fmt.Errorf("failed to install cargo-pgrx %s: %w", requiredVersion, err)
 	}
 
 	return nil
 }
 
+// Toolchain returns the cargo-pgrx and rustc versions used to build the project.
+// The required cargo-pgrx version is used when known, since EnsurePgrxVersion
+// installs a matching version before building.
+func Toolchain(dir string) string {
+	pgrxVersion, err := GetPgrxVersion(dir)
+	if err != nil {
+		pgrxVersion, _ = GetInstalledPgrxVersion()
+	}
+
+	rustc := "rustc unknown"
+	if output, err := exec.Command("rustc", "--version").Output(); err == nil {
+		rustc = strings.TrimSpace(string(output))
+	}
+
+	return fmt.Sprintf("cargo-pgrx %s, %s", pgrxVersion, rustc)
+}
+
+// cargoEnv returns the environment for cargo commands. In offline mode cargo
+// must build from the local registry cache (populated by 'pgx fetch').
+func cargoEnv(opts InstallOptions) []string {
+	env := append(os.Environ(), opts.Env...)
+	if opts.CC != "" {
+		env = append(env, "CC="+opts.CC)
+	}
+	if offline.Enabled() {
+		env = append(env, "CARGO_NET_OFFLINE=true")
+	}
+	return env
+}
+
+// Fetch downloads the crate dependencies of the project into cargo's local
+// registry cache, so it can later be built offline.
+func Fetch(dir string) error {
+	cmd := exec.Command("cargo", "fetch")
+	cmd.Dir = dir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("cargo fetch failed: %w", err)
+	}
+	return nil
+}
+
 // getPgMajorVersion returns the PostgreSQL major version from pg_config.
 func getPgMajorVersion(pgConfig string) (string, error) {
 	cmd := exec.Command(pgConfig, "--version")
@@ -253,7 +327,8 @@ func isPgrxInitialized(pgMajorVersion string) bool {
 }
 
 // EnsurePgrxInit ensures pgrx is initialized for the current PostgreSQL version.
-func EnsurePgrxInit(pgConfig string) error {
+// Output of cargo pgrx init goes to log (a nil log streams it).
+func EnsurePgrxInit(pgConfig string, log *buildlog.Log) error {
 	pgMajorVersion, err := getPgMajorVersion(pgConfig)
 	if err != nil {
 		return fmt.Errorf("could not determine PostgreSQL version: %w", err)
@@ -263,17 +338,13 @@ func EnsurePgrxInit(pgConfig string) error {
 		return nil
 	}
 
-	fmt.Printf("Initializing pgrx for pg%s...\n", pgMajorVersion)
 	arg := fmt.Sprintf("--pg%s=%s", pgMajorVersion, pgConfig)
 	cmd := exec.Command("cargo", "pgrx", "init", arg)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
 
-	if err := cmd.Run(); err != nil {
+	if err := log.Run(fmt.Sprintf("Initializing pgrx for pg%s", pgMajorVersion), cmd); err != nil {
 		return fmt.Errorf("failed to initialize pgrx for pg%s: %w", pgMajorVersion, err)
 	}
 
-	fmt.Printf("pgrx initialized for pg%s\n", pgMajorVersion)
 	return nil
 }
 
@@ -291,79 +362,269 @@ func hasMakefileWithInstall(dir string) bool {
 
 // InstallOptions contains options for the Install function.
 type InstallOptions struct {
-	PgConfig string // Path to pg_config
-	UseSudo  bool   // Use sudo for installation
+	PgConfig      string        // Path to pg_config
+	UseSudo       bool          // Use sudo for installation
+	DestDir       string        // Stage files under this directory instead of the live tree
+	MakeArgs      []string      // Extra make arguments for projects with a custom Makefile
+	CargoFeatures []string      // Extra cargo features
+	Env           []string      // Extra environment variables (KEY=VAL)
+	CC            string        // C compiler for build scripts (cc crate)
+	UseMakefile   *bool         // Build with the project's Makefile (nil: detect)
+	Log           *buildlog.Log // Captures build output (nil streams it)
 }
 
 // Install builds and installs the extension using cargo pgrx install.
 func Install(dir string, opts InstallOptions) error {
-	// Determine pg_config path
-	pgConfig := opts.PgConfig
-	if pgConfig == "" {
-		pgConfig = os.Getenv("PG_CONFIG")
-	}
-	if pgConfig == "" {
-		pgConfig = "pg_config"
-	}
+	pgConfig := pgConfigFor(opts)
 
-	// Check if custom Makefile exists with install target
+	// Check if custom Makefile exists with install target, unless the manifest
+	// says whether to use it
 	// This allows pgrx projects to have custom build steps (e.g., venv setup)
-	// The Makefile is expected to handle sudo internally based on PG_CONFIG path detection
-	if hasMakefileWithInstall(dir) {
-		fmt.Println("==> Found Makefile with install target, using make...")
-		cmd := exec.Command("make", "install", "PG_CONFIG="+pgConfig)
+	// The Makefile must install under DESTDIR; pgx deploys the staged files
+	// itself (with sudo if needed), so make never runs with sudo
+	useMakefile := hasMakefileWithInstall(dir)
+	if opts.UseMakefile != nil {
+		useMakefile = *opts.UseMakefile
+	}
+	if useMakefile {
+		fmt.Println("Found Makefile with install target, using make")
+		makeArgs := []string{"install", "PG_CONFIG=" + pgConfig}
+		if opts.DestDir != "" {
+			makeArgs = append(makeArgs, "DESTDIR="+opts.DestDir)
+		}
+		makeArgs = append(makeArgs, opts.MakeArgs...)
+		cmd := exec.Command("make", makeArgs...)
 		cmd.Dir = dir
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		if err := cmd.Run(); err != nil {
+		cmd.Env = cargoEnv(opts)
+
+		// A Makefile that ignores DESTDIR installs into the live
+		// installation; catch that rather than leave untracked files there
+		var liveDirs []string
+		var before map[string]string
+		if opts.DestDir != "" {
+			liveDirs = installDirs(pgConfig)
+			before = snapshot(liveDirs)
+		}
+		if err := opts.Log.Run("Running make install", cmd); err != nil {
 			return fmt.Errorf("make install failed: %w", err)
 		}
-		return nil
-	}
-
-	// Check pgrx version compatibility
-	requiredVersion, err := GetPgrxVersion(dir)
-	if err == nil && requiredVersion != "" {
-		if err := EnsurePgrxVersion(requiredVersion); err != nil {
-			return err
+		if opts.DestDir != "" {
+			if written := changedSince(liveDirs, before); len(written) > 0 {
+				return fmt.Errorf("make install wrote to the PostgreSQL installation instead of DESTDIR; the Makefile must install under $(DESTDIR), or set use_makefile = false in pgbrew.toml\n"+
+					"  Files written outside the staging directory (not tracked by pgx, remove them by hand):\n    %s", strings.Join(written, "\n    "))
+			}
 		}
+		return nil
 	}
 
-	// Ensure pgrx is initialized for this PostgreSQL version
-	if err := EnsurePgrxInit(pgConfig); err != nil {
-		return err
-	}
-
-	// Get PostgreSQL major version
-	pgMajorVersion, err := getPgMajorVersion(pgConfig)
+	pgMajorVersion, err := prepareToolchain(dir, pgConfig, opts.Log)
 	if err != nil {
-		return fmt.Errorf("could not determine PostgreSQL version: %w", err)
+		return err
 	}
 
-	// Build command args
+	// Build command args. When staging, cargo pgrx package lays out the
+	// same files as install, but under the output directory.
 	args := []string{"pgrx", "install", "--release"}
+	if opts.DestDir != "" {
+		args = []string{"pgrx", "package", "--out-dir", opts.DestDir}
+	}
 
 	// Pass pg_config path
 	args = append(args, "--pg-config", pgConfig)
 
-	// Disable default features and specify only the correct pg version feature
-	pgFeature := "pg" + pgMajorVersion
-	args = append(args, "--no-default-features", "--features", pgFeature)
+	args = append(args, featureArgs(pgMajorVersion, opts)...)
 
 	// Add sudo flag if requested
-	if opts.UseSudo {
+	if opts.UseSudo && opts.DestDir == "" {
 		args = append(args, "--sudo")
 	}
 
-	// Run cargo pgrx install
+	// Run cargo pgrx install (or package)
 	cmd := exec.Command("cargo", args...)
 	cmd.Dir = dir
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd.Env = cargoEnv(opts)
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("cargo pgrx install failed: %w", err)
+	if err := opts.Log.Run("Running cargo pgrx "+args[1], cmd); err != nil {
+		return fmt.Errorf("cargo pgrx %s failed: %w", args[1], err)
+	}
+
+	return nil
+}
+
+// installDirs returns the directories of the PostgreSQL of pgConfig that
+// extensions install into: pkglibdir and sharedir/extension.
+func installDirs(pgConfig string) []string {
+	var dirs []string
+	for _, flag := range []string{"--pkglibdir", "--sharedir"} {
+		output, err := exec.Command(pgConfig, flag).Output()
+		if err != nil {
+			continue
+		}
+		dir := strings.TrimSpace(string(output))
+		if flag == "--sharedir" {
+			dir = filepath.Join(dir, "extension")
+		}
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
+// snapshot records the size and modification time of every file in dirs.
+func snapshot(dirs []string) map[string]string {
+	files := map[string]string{}
+	for _, dir := range dirs {
+		filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			// Skip pgx's own files, such as the cellar
+			if err != nil || d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+				return nil
+			}
+			if info, err := d.Info(); err == nil {
+				files[path] = fmt.Sprintf("%d %d", info.Size(), info.ModTime().UnixNano())
+			}
+			return nil
+		})
+	}
+	return files
+}
+
+// changedSince returns the files in dirs that were added or changed since
+// the snapshot before.
+func changedSince(dirs []string, before map[string]string) []string {
+	var changed []string
+	for path, state := range snapshot(dirs) {
+		if before[path] != state {
+			changed = append(changed, path)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// Test runs the project's tests with cargo pgrx test. pgrx builds the
+// extension, installs it into the PostgreSQL it is initialized for, starts
+// its own cluster there and stops it when the tests finish. The tests run
+// with a private PGRX_HOME initialized for opts.PgConfig only, so pass the
+// pg_config of a throwaway installation to keep the untested build out of
+// a real one.
+func Test(dir string, opts InstallOptions) error {
+	pgConfig := pgConfigFor(opts)
+	pgMajorVersion, err := prepareCargoPgrx(dir, pgConfig, opts.Log)
+	if err != nil {
+		return err
 	}
 
+	home, err := os.MkdirTemp("", "pgbrew-pgrx-*")
+	if err != nil {
+		return fmt.Errorf("failed to create PGRX_HOME: %w", err)
+	}
+	defer os.RemoveAll(home)
+	config := fmt.Sprintf("[configs]\npg%s = %q\n", pgMajorVersion, pgConfig)
+	if err := os.WriteFile(filepath.Join(home, "config.toml"), []byte(config), 0644); err != nil {
+		return fmt.Errorf("failed to create PGRX_HOME: %w", err)
+	}
+
+	args := append([]string{"pgrx", "test", "pg" + pgMajorVersion}, featureArgs(pgMajorVersion, opts)...)
+	cmd := exec.Command("cargo", args...)
+	cmd.Dir = dir
+	cmd.Env = append(cargoEnv(opts), "PGRX_HOME="+home)
+	if err := opts.Log.Run("Running cargo pgrx test", cmd); err != nil {
+		return fmt.Errorf("cargo pgrx test failed: %w", err)
+	}
 	return nil
 }
+
+// pgConfigFor returns the pg_config path to build against.
+func pgConfigFor(opts InstallOptions) string {
+	return PgConfigPath(opts.PgConfig)
+}
+
+// PgConfigPath returns the pg_config to build against: the given path, else
+// $PG_CONFIG, else pg_config from the PATH.
+func PgConfigPath(path string) string {
+	if path != "" {
+		return path
+	}
+	if pgConfig := os.Getenv("PG_CONFIG"); pgConfig != "" {
+		return pgConfig
+	}
+	return "pg_config"
+}
+
+// prepareToolchain installs the cargo-pgrx version the project requires and
+// initializes pgrx for pgConfig. Returns the PostgreSQL major version.
+func prepareToolchain(dir string, pgConfig string, log *buildlog.Log) (string, error) {
+	pgMajorVersion, err := prepareCargoPgrx(dir, pgConfig, log)
+	if err != nil {
+		return "", err
+	}
+
+	// Ensure pgrx is initialized for this PostgreSQL version
+	if err := EnsurePgrxInit(pgConfig, log); err != nil {
+		return "", err
+	}
+	return pgMajorVersion, nil
+}
+
+// prepareCargoPgrx checks that the project supports the PostgreSQL of
+// pgConfig and installs the cargo-pgrx version it requires. Returns the
+// PostgreSQL major version.
+func prepareCargoPgrx(dir string, pgConfig string, log *buildlog.Log) (string, error) {
+	pgMajorVersion, err := getPgMajorVersion(pgConfig)
+	if err != nil {
+		return "", fmt.Errorf("could not determine PostgreSQL version: %w", err)
+	}
+	if supported, err := SupportsPostgres(dir, pgMajorVersion); err == nil && !supported {
+		return "", fmt.Errorf("extension does not support PostgreSQL %s (Cargo.toml has no pg%s feature)", pgMajorVersion, pgMajorVersion)
+	}
+
+	// Check pgrx version compatibility
+	requiredVersion, err := GetPgrxVersion(dir)
+	if err == nil && requiredVersion != "" {
+		if err := EnsurePgrxVersion(requiredVersion, log); err != nil {
+			return "", err
+		}
+	}
+	return pgMajorVersion, nil
+}
+
+// pgFeature matches a PostgreSQL version feature (pg16 = ["pgrx/pg16"])
+var pgFeature = regexp.MustCompile(`(?m)^\s*"?pg(\d+)"?\s*=`)
+
+// SupportsPostgres reports whether a pgrx project builds for a PostgreSQL
+// major version, from the pgNN features in its Cargo.toml. Projects without
+// any such features are assumed to support it.
+func SupportsPostgres(dir string, pgMajorVersion string) (bool, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "Cargo.toml"))
+	if err != nil {
+		return false, err
+	}
+
+	// Only look at the [features] table
+	content := string(data)
+	start := strings.Index(content, "[features]")
+	if start == -1 {
+		return true, nil
+	}
+	features := content[start+len("[features]"):]
+	if end := strings.Index(features, "\n["); end != -1 {
+		features = features[:end]
+	}
+
+	matches := pgFeature.FindAllStringSubmatch(features, -1)
+	if len(matches) == 0 {
+		return true, nil
+	}
+	for _, m := range matches {
+		if m[1] == pgMajorVersion {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// featureArgs disables default features and selects only the feature for
+// the PostgreSQL version, plus any features requested by the user.
+func featureArgs(pgMajorVersion string, opts InstallOptions) []string {
+	features := append([]string{"pg" + pgMajorVersion}, opts.CargoFeatures...)
+	return []string{"--no-default-features", "--features", strings.Join(features, " ")}
+}
@@ -0,0 +1,346 @@
+package pgxn
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/matroidbe/pgbrew/internal/cache"
+	"github.com/matroidbe/pgbrew/internal/offline"
+)
+
+// DefaultURL is the PGXN API server.
+const DefaultURL = "https://api.pgxn.org"
+
+// maxAge is how long the cached index is used without fetching it again
+const maxAge = 7 * 24 * time.Hour
+
+// workers is the number of metadata files fetched at once
+const workers = 8
+
+// Extension is an extension found on PGXN.
+type Extension struct {
+	Name        string   `json:"extension"`
+	Description string   `json:"abstract"`
+	Dist        string   `json:"dist"`    // Distribution containing the extension
+	Version     string   `json:"version"` // Latest version of the distribution
+	Tags        []string `json:"tags,omitempty"`
+}
+
+// Dist is a distribution in the index, from its metadata.
+type Dist struct {
+	Name     string   `json:"name"`
+	Version  string   `json:"version"`
+	Abstract string   `json:"abstract"`
+	Tags     []string `json:"tags,omitempty"`
+	Provides map[string]struct {
+		Abstract string `json:"abstract"`
+	} `json:"provides"`
+}
+
+// Index is a local copy of the metadata of every distribution on a PGXN
+// server, searched without asking the server.
+type Index struct {
+	URL       string    `json:"url"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Dists     []Dist    `json:"dists"`
+}
+
+// baseURL is the API server to query (from the pgxn_url setting)
+var baseURL = DefaultURL
+
+// SetBaseURL sets the API server to query ("" for the default).
+func SetBaseURL(u string) {
+	if u == "" {
+		u = DefaultURL
+	}
+	baseURL = strings.TrimSuffix(u, "/")
+}
+
+// Search returns the extensions in the index whose name, abstract,
+// distribution or tags contain term. The index is fetched when it is older
+// than a week; in offline mode, or when PGXN can't be reached, the cached
+// index of any age is used. stale reports that the results come from such
+// a fallback.
+func Search(term string) (results []Extension, stale bool, err error) {
+	idx, err := readIndex()
+	if err == nil && idx.URL == baseURL && time.Since(idx.UpdatedAt) < maxAge {
+		return idx.Search(term), false, nil
+	}
+	if idx != nil && idx.URL != baseURL {
+		idx = nil
+	}
+
+	if offline.Enabled() {
+		if idx == nil {
+			return nil, false, offline.Missing("the PGXN index",
+				"Run 'pgx search --update-index' with network access to cache it.")
+		}
+		return idx.Search(term), true, nil
+	}
+
+	fresh, err := Update()
+	if err != nil {
+		if idx != nil {
+			return idx.Search(term), true, nil
+		}
+		return nil, false, err
+	}
+	return fresh.Search(term), false, nil
+}
+
+// NeedsUpdate reports whether the next Search fetches the index.
+func NeedsUpdate() bool {
+	idx, err := readIndex()
+	return err != nil || idx.URL != baseURL || time.Since(idx.UpdatedAt) >= maxAge
+}
+
+// Search returns the extensions matching term (case-insensitive), exact
+// name matches first.
+func (idx *Index) Search(term string) []Extension {
+	term = strings.ToLower(term)
+	var results []Extension
+	for _, d := range idx.Dists {
+		distMatches := contains(d.Name, term) || contains(d.Abstract, term)
+		for _, tag := range d.Tags {
+			distMatches = distMatches || contains(tag, term)
+		}
+		for name, p := range d.Provides {
+			if !distMatches && !contains(name, term) && !contains(p.Abstract, term) {
+				continue
+			}
+			description := p.Abstract
+			if description == "" {
+				description = d.Abstract
+			}
+			results = append(results, Extension{Name: name, Description: description, Dist: d.Name, Version: d.Version, Tags: d.Tags})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		ei, ej := strings.ToLower(results[i].Name) == term, strings.ToLower(results[j].Name) == term
+		if ei != ej {
+			return ei
+		}
+		return results[i].Name < results[j].Name
+	})
+	return results
+}
+
+func contains(s, term string) bool {
+	return strings.Contains(strings.ToLower(s), term)
+}
+
+// Update fetches the metadata of every distribution from the server and
+// caches it as the index. The server's index.json names where everything
+// is: the users by first letter, each user's releases, and each
+// distribution's metadata.
+func Update() (*Index, error) {
+	if offline.Enabled() {
+		return nil, offline.Missing("the PGXN index", "Update it with network access first.")
+	}
+
+	var templates map[string]string
+	if err := getJSON(baseURL+"/index.json", &templates); err != nil {
+		return nil, fmt.Errorf("PGXN index update failed: %w", err)
+	}
+	for _, t := range []string{"userlist", "user", "dist"} {
+		if templates[t] == "" {
+			return nil, fmt.Errorf("PGXN index update failed: %s/index.json has no %q template", baseURL, t)
+		}
+	}
+
+	// Users, by first letter
+	var letters []string
+	for c := 'a'; c <= 'z'; c++ {
+		letters = append(letters, string(c))
+	}
+	var users []string
+	var mu sync.Mutex
+	err := fetchAll(letters, func(letter string) error {
+		var list []struct {
+			User string `json:"user"`
+		}
+		if err := getJSON(expand(templates["userlist"], "letter", letter), &list); err != nil {
+			if isNotFound(err) {
+				return nil
+			}
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, u := range list {
+			users = append(users, u.User)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("PGXN index update failed: %w", err)
+	}
+
+	// Distributions, from each user's releases
+	distSet := map[string]bool{}
+	err = fetchAll(users, func(user string) error {
+		var u struct {
+			Releases map[string]json.RawMessage `json:"releases"`
+		}
+		if err := getJSON(expand(templates["user"], "user", strings.ToLower(user)), &u); err != nil {
+			if isNotFound(err) {
+				return nil
+			}
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for dist := range u.Releases {
+			distSet[dist] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("PGXN index update failed: %w", err)
+	}
+
+	var names []string
+	for dist := range distSet {
+		names = append(names, dist)
+	}
+	sort.Strings(names)
+
+	idx := &Index{URL: baseURL, UpdatedAt: time.Now()}
+	err = fetchAll(names, func(name string) error {
+		var d Dist
+		if err := getJSON(expand(templates["dist"], "dist", strings.ToLower(name)), &d); err != nil {
+			if isNotFound(err) {
+				return nil
+			}
+			return err
+		}
+		if d.Name == "" {
+			d.Name = name
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		idx.Dists = append(idx.Dists, d)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("PGXN index update failed: %w", err)
+	}
+	sort.Slice(idx.Dists, func(i, j int) bool { return idx.Dists[i].Name < idx.Dists[j].Name })
+
+	if err := writeIndex(idx); err != nil {
+		return nil, fmt.Errorf("failed to cache PGXN index: %w", err)
+	}
+	return idx, nil
+}
+
+// fetchAll calls fetch for each item, a few at a time, and returns the
+// first error.
+func fetchAll(items []string, fetch func(string) error) error {
+	work := make(chan string)
+	errs := make(chan error, len(items))
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range work {
+				if err := fetch(item); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	for _, item := range items {
+		work <- item
+	}
+	close(work)
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+// expand fills a variable of a URI template from index.json.
+func expand(template, name, value string) string {
+	return baseURL + strings.ReplaceAll(template, "{"+name+"}", value)
+}
+
+// notFoundError is a 404 from the server, for files that may be absent.
+type notFoundError struct{ url string }
+
+func (e *notFoundError) Error() string { return e.url + " not found" }
+
+func isNotFound(err error) bool {
+	_, ok := err.(*notFoundError)
+	return ok
+}
+
+var client = &http.Client{Timeout: 15 * time.Second}
+
+func getJSON(u string, v any) error {
+	resp, err := client.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return &notFoundError{u}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", u, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("invalid response from %s: %w", u, err)
+	}
+	return nil
+}
+
+// indexPath returns the cache file of the index.
+func indexPath() (string, error) {
+	dir, err := cache.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "pgxn", "index.json"), nil
+}
+
+func readIndex() (*Index, error) {
+	path, err := indexPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var idx Index
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return nil, err
+	}
+	return &idx, nil
+}
+
+func writeIndex(idx *Index) error {
+	path, err := indexPath()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
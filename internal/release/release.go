@@ -0,0 +1,240 @@
+package release
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultURL is where pgx releases are published. A release's files are at
+// <base>/download/<tag>/<file>, and those of the latest release at
+// <base>/latest/download/<file>.
+const DefaultURL = "https://github.com/matroidbe/pgbrew/releases"
+
+// Files published with every release
+const (
+	VersionFile   = "VERSION"           // Tag of the release (unsigned, only names the latest)
+	ChecksumsFile = "checksums.txt"     // "# tag: <tag>" then sha256sum output for the binaries
+	SignatureFile = "checksums.txt.sig" // Base64 ed25519 signature of checksums.txt
+)
+
+// tagPrefix starts the line of the checksums file naming its release, so a
+// signed checksums file can't be passed off as another release's.
+const tagPrefix = "# tag: "
+
+// baseURL is the release location (from the release_url setting). It may
+// also be a local directory laid out the same way.
+var baseURL = DefaultURL
+
+// PublicKey is the base64 ed25519 key releases are signed with. It is set at
+// build time and can be replaced by the release_public_key setting of the
+// system configuration.
+var PublicKey = ""
+
+// publicKey verifies release signatures
+var publicKey ed25519.PublicKey
+
+// SetBaseURL sets the release location ("" for the default).
+func SetBaseURL(u string) {
+	if u == "" {
+		u = DefaultURL
+	}
+	baseURL = strings.TrimSuffix(u, "/")
+}
+
+// SetPublicKey sets the base64 ed25519 public key release checksums must be
+// signed with ("" for the built-in key).
+func SetPublicKey(key string) error {
+	if key == "" {
+		key = PublicKey
+	}
+	if key == "" {
+		publicKey = nil
+		return nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	if err != nil || len(raw) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid release public key: expected %d base64-encoded bytes", ed25519.PublicKeySize)
+	}
+	publicKey = raw
+	return nil
+}
+
+// IsRemote reports whether releases are downloaded, rather than read from a
+// local directory.
+func IsRemote() bool {
+	return strings.HasPrefix(baseURL, "http://") || strings.HasPrefix(baseURL, "https://")
+}
+
+// AssetName returns the name of the release binary for this OS and
+// architecture, e.g. pgx_linux_amd64.
+func AssetName() string {
+	return fmt.Sprintf("pgx_%s_%s", runtime.GOOS, runtime.GOARCH)
+}
+
+// Latest returns the tag of the latest release.
+func Latest() (string, error) {
+	data, err := fetch("", VersionFile)
+	if err != nil {
+		return "", fmt.Errorf("could not determine the latest release: %w", err)
+	}
+	tag := strings.TrimSpace(string(data))
+	if tag == "" {
+		return "", fmt.Errorf("could not determine the latest release: %s is empty", VersionFile)
+	}
+	return tag, nil
+}
+
+// Checksum returns the verified sha256 checksum of the binary for this
+// platform in a release. The checksums file must carry a valid signature and
+// name the release as tag.
+func Checksum(tag string) (string, error) {
+	if publicKey == nil {
+		return "", fmt.Errorf("no release public key is configured, so release binaries can't be verified (set release_public_key)")
+	}
+
+	checksums, err := fetch(tag, ChecksumsFile)
+	if err != nil {
+		return "", err
+	}
+	signature, err := fetch(tag, SignatureFile)
+	if err != nil {
+		return "", err
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil || !ed25519.Verify(publicKey, checksums, sig) {
+		return "", fmt.Errorf("the signature of %s for %s is not valid", ChecksumsFile, tag)
+	}
+
+	asset := AssetName()
+	signedTag, sum := "", ""
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if t, ok := strings.CutPrefix(line, tagPrefix); ok {
+			signedTag = strings.TrimSpace(t)
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == asset {
+			sum = fields[0]
+		}
+	}
+	if signedTag != tag {
+		if signedTag == "" {
+			return "", fmt.Errorf("%s of %s does not name its release", ChecksumsFile, tag)
+		}
+		return "", fmt.Errorf("%s of %s is signed for release %s", ChecksumsFile, tag, signedTag)
+	}
+	if sum == "" {
+		return "", fmt.Errorf("release %s has no binary for %s/%s", tag, runtime.GOOS, runtime.GOARCH)
+	}
+	return sum, nil
+}
+
+// Download fetches the binary for this platform from a release into dir and
+// checks it against the signed checksums. It returns the path of the binary.
+func Download(tag string, dir string) (string, error) {
+	sum, err := Checksum(tag)
+	if err != nil {
+		return "", err
+	}
+	data, err := fetch(tag, AssetName())
+	if err != nil {
+		return "", err
+	}
+	actual := sha256.Sum256(data)
+	if hex.EncodeToString(actual[:]) != strings.ToLower(sum) {
+		return "", fmt.Errorf("checksum mismatch for %s %s: the download is corrupt or was tampered with", AssetName(), tag)
+	}
+
+	path := filepath.Join(dir, AssetName())
+	if err := os.WriteFile(path, data, 0755); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// IsDowngrade reports whether installing release tag would replace release
+// current with an older one, or one that can't be ordered against it.
+// Development builds, whose version isn't a release tag, can be replaced by
+// any release.
+func IsDowngrade(tag string, current string) bool {
+	cur, ok := parseVersion(current)
+	if !ok {
+		return false
+	}
+	t, ok := parseVersion(tag)
+	if !ok {
+		return true
+	}
+	for i := range cur {
+		if t[i] != cur[i] {
+			return t[i] < cur[i]
+		}
+	}
+	return false
+}
+
+// parseVersion parses a tag like v1.2.3 (or v1.2.3-rc1, or git describe's
+// v1.2.3-4-gabcdef) into its major, minor and patch numbers.
+func parseVersion(tag string) ([3]int, bool) {
+	var v [3]int
+	core, _, _ := strings.Cut(strings.TrimPrefix(tag, "v"), "-")
+	core, _, _ = strings.Cut(core, "+")
+	parts := strings.Split(core, ".")
+	if len(parts) > 3 {
+		return v, false
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return v, false
+		}
+		v[i] = n
+	}
+	return v, true
+}
+
+// fetch returns a file of a release ("" for the latest).
+func fetch(tag string, file string) ([]byte, error) {
+	dir := "latest/download"
+	if tag != "" {
+		dir = "download/" + tag
+	}
+	location := baseURL + "/" + dir + "/" + file
+
+	if !strings.HasPrefix(location, "http://") && !strings.HasPrefix(location, "https://") {
+		data, err := os.ReadFile(filepath.FromSlash(strings.TrimPrefix(location, "file://")))
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%s not found", location)
+		}
+		return data, err
+	}
+
+	client := &http.Client{Timeout: 5 * time.Minute}
+	resp, err := client.Get(location)
+	if err != nil {
+		return nil, fmt.Errorf("download of %s failed: %w", location, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%s not found", location)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download of %s failed: %s", location, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
@@ -0,0 +1,168 @@
+package release
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testRelease is a release published into a local directory.
+type testRelease struct {
+	tag       string
+	signedTag string             // Tag named in checksums.txt
+	binary    []byte             // Published binary
+	sumOf     []byte             // Content checksums.txt lists for the binary
+	asset     string             // Asset listed in checksums.txt
+	signWith  ed25519.PrivateKey // Key checksums.txt is signed with
+	noBinary  bool               // Leave the binary out
+}
+
+// publish writes the release under base, laid out like a release location.
+func (r testRelease) publish(t *testing.T, base string) {
+	t.Helper()
+	dir := filepath.Join(base, "download", r.tag)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(r.sumOf)
+	checksums := fmt.Sprintf("# tag: %s\n%s  %s\n", r.signedTag, hex.EncodeToString(sum[:]), r.asset)
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(r.signWith, []byte(checksums)))
+
+	files := map[string][]byte{
+		ChecksumsFile: []byte(checksums),
+		SignatureFile: []byte(signature),
+	}
+	if !r.noBinary {
+		files[AssetName()] = r.binary
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func generateKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return public, private
+}
+
+func TestDownload(t *testing.T) {
+	public, private := generateKey(t)
+	_, otherKey := generateKey(t)
+	binary := []byte("#!/bin/sh\necho pgx\n")
+
+	good := testRelease{tag: "v1.2.0", signedTag: "v1.2.0", binary: binary, sumOf: binary, asset: AssetName(), signWith: private}
+
+	tests := []struct {
+		name    string
+		release func(r testRelease) testRelease
+		wantErr string
+	}{
+		{"good signature", func(r testRelease) testRelease { return r }, ""},
+		{"bad signature", func(r testRelease) testRelease { r.signWith = otherKey; return r }, "signature"},
+		{"checksum mismatch", func(r testRelease) testRelease { r.sumOf = []byte("something else"); return r }, "checksum mismatch"},
+		{"missing asset", func(r testRelease) testRelease { r.noBinary = true; return r }, "not found"},
+		{"asset not in checksums", func(r testRelease) testRelease { r.asset = "pgx_plan9_mips"; return r }, "has no binary"},
+		{"signed for another release", func(r testRelease) testRelease { r.signedTag = "v1.1.0"; return r }, "signed for release v1.1.0"},
+		{"no signed tag", func(r testRelease) testRelease { r.signedTag = ""; return r }, "does not name its release"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := t.TempDir()
+			tt.release(good).publish(t, base)
+			SetBaseURL(base)
+			defer SetBaseURL("")
+			if err := SetPublicKey(base64.StdEncoding.EncodeToString(public)); err != nil {
+				t.Fatal(err)
+			}
+			defer SetPublicKey("")
+
+			path, err := Download(good.tag, t.TempDir())
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Download: %v", err)
+				}
+				data, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(data) != string(binary) {
+					t.Errorf("downloaded %q, want %q", data, binary)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Download error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestChecksumWithoutKey(t *testing.T) {
+	SetBaseURL(t.TempDir())
+	defer SetBaseURL("")
+	if err := SetPublicKey(""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Checksum("v1.0.0"); err == nil || !strings.Contains(err.Error(), "no release public key") {
+		t.Fatalf("Checksum error = %v, want a missing key error", err)
+	}
+}
+
+func TestLatest(t *testing.T) {
+	base := t.TempDir()
+	SetBaseURL(base)
+	defer SetBaseURL("")
+
+	if _, err := Latest(); err == nil {
+		t.Fatal("Latest succeeded without a VERSION file")
+	}
+	dir := filepath.Join(base, "latest", "download")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, VersionFile), []byte("v1.2.0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if tag, err := Latest(); err != nil || tag != "v1.2.0" {
+		t.Fatalf("Latest = %q, %v, want v1.2.0", tag, err)
+	}
+	if IsRemote() {
+		t.Error("IsRemote is true for a local directory")
+	}
+}
+
+func TestIsDowngrade(t *testing.T) {
+	tests := []struct {
+		tag, current string
+		want         bool
+	}{
+		{"v1.2.0", "v1.1.0", false},
+		{"v1.2.0", "v1.2.0", false},
+		{"v1.10.0", "v1.9.3", false},
+		{"v2.0", "v1.9.9", false},
+		{"v1.1.0", "v1.2.0", true},
+		{"v1.2.0", "v1.2.1", true},
+		{"v0.9.0", "v1.0.0-3-gabcdef-dirty", true},
+		{"v1.0.0", "dev", false},
+		{"nonsense", "dev", false},
+		{"nonsense", "v1.0.0", true},
+	}
+	for _, tt := range tests {
+		if got := IsDowngrade(tt.tag, tt.current); got != tt.want {
+			t.Errorf("IsDowngrade(%q, %q) = %v, want %v", tt.tag, tt.current, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package sharedlib
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Distribution families, as used for package hints
+const (
+	Debian = "debian"
+	RedHat = "redhat"
+	Arch   = "arch"
+	Darwin = "darwin"
+)
+
+// packages maps the base name of common libraries to the package providing
+// them, per distribution family. Debian names may contain %s for the
+// soname version (e.g. librdkafka.so.1 -> librdkafka1).
+var packages = map[string]map[string]string{
+	"librdkafka":    {Debian: "librdkafka%s", RedHat: "librdkafka", Arch: "librdkafka", Darwin: "librdkafka"},
+	"libssl":        {Debian: "libssl%s", RedHat: "openssl-libs", Arch: "openssl", Darwin: "openssl"},
+	"libcrypto":     {Debian: "libssl%s", RedHat: "openssl-libs", Arch: "openssl", Darwin: "openssl"},
+	"libicuuc":      {Debian: "libicu%s", RedHat: "libicu", Arch: "icu", Darwin: "icu4c"},
+	"libicui18n":    {Debian: "libicu%s", RedHat: "libicu", Arch: "icu", Darwin: "icu4c"},
+	"libicudata":    {Debian: "libicu%s", RedHat: "libicu", Arch: "icu", Darwin: "icu4c"},
+	"libxml2":       {Debian: "libxml2", RedHat: "libxml2", Arch: "libxml2", Darwin: "libxml2"},
+	"libz":          {Debian: "zlib1g", RedHat: "zlib", Arch: "zlib", Darwin: "zlib"},
+	"libzstd":       {Debian: "libzstd1", RedHat: "libzstd", Arch: "zstd", Darwin: "zstd"},
+	"liblz4":        {Debian: "liblz4-1", RedHat: "lz4-libs", Arch: "lz4", Darwin: "lz4"},
+	"libcurl":       {Debian: "libcurl4", RedHat: "libcurl", Arch: "curl", Darwin: "curl"},
+	"libpq":         {Debian: "libpq5", RedHat: "libpq", Arch: "postgresql-libs", Darwin: "libpq"},
+	"libgeos_c":     {Debian: "libgeos-c1v5", RedHat: "geos", Arch: "geos", Darwin: "geos"},
+	"libproj":       {Debian: "libproj%s", RedHat: "proj", Arch: "proj", Darwin: "proj"},
+	"libgdal":       {Debian: "libgdal%s", RedHat: "gdal-libs", Arch: "gdal", Darwin: "gdal"},
+	"libprotobuf-c": {Debian: "libprotobuf-c1", RedHat: "protobuf-c", Arch: "protobuf-c", Darwin: "protobuf-c"},
+	"libsasl2":      {Debian: "libsasl2-2", RedHat: "cyrus-sasl-lib", Arch: "libsasl", Darwin: "cyrus-sasl"},
+	"libpcre2-8":    {Debian: "libpcre2-8-0", RedHat: "pcre2", Arch: "pcre2", Darwin: "pcre2"},
+	"libjson-c":     {Debian: "libjson-c5", RedHat: "json-c", Arch: "json-c", Darwin: "json-c"},
+	"libsodium":     {Debian: "libsodium23", RedHat: "libsodium", Arch: "libsodium", Darwin: "libsodium"},
+	"libuuid":       {Debian: "libuuid1", RedHat: "libuuid", Arch: "util-linux-libs", Darwin: "ossp-uuid"},
+	"libstdc++":     {Debian: "libstdc++6", RedHat: "libstdc++", Arch: "gcc-libs", Darwin: "gcc"},
+	"libgomp":       {Debian: "libgomp1", RedHat: "libgomp", Arch: "gcc-libs", Darwin: "gcc"},
+}
+
+// Package returns the package that provides soname on a distribution
+// family, or "" if it is not known.
+func Package(soname string, family string) string {
+	base, version := splitSoname(soname)
+	pkg := packages[base][family]
+	if strings.Contains(pkg, "%s") {
+		if version == "" {
+			return ""
+		}
+		pkg = fmt.Sprintf(pkg, version)
+	}
+	return pkg
+}
+
+// splitSoname splits "libssl.so.1.1" into "libssl" and "1.1".
+func splitSoname(soname string) (base string, version string) {
+	base, version, _ = strings.Cut(soname, ".so")
+	return base, strings.TrimPrefix(version, ".")
+}
+
+// PackageInstalled reports whether a package is installed, using the
+// package manager of the distribution family. checked is false if the
+// package manager is not available.
+func PackageInstalled(pkg string, family string) (installed bool, checked bool) {
+	var cmd *exec.Cmd
+	switch family {
+	case Debian:
+		cmd = exec.Command("dpkg-query", "-W", "-f=${Status}", pkg)
+	case RedHat:
+		cmd = exec.Command("rpm", "-q", pkg)
+	case Arch:
+		cmd = exec.Command("pacman", "-Q", pkg)
+	case Darwin:
+		cmd = exec.Command("brew", "list", "--versions", pkg)
+	default:
+		return false, false
+	}
+	if _, err := exec.LookPath(cmd.Path); err != nil {
+		return false, false
+	}
+
+	output, err := cmd.Output()
+	if err != nil {
+		return false, true
+	}
+	if family == Debian {
+		return strings.TrimSpace(string(output)) == "install ok installed", true
+	}
+	return strings.TrimSpace(string(output)) != "", true
+}
@@ -0,0 +1,214 @@
+package sharedlib
+
+import (
+	"bufio"
+	"debug/elf"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Library is a shared library a module depends on.
+type Library struct {
+	Name string // Soname from DT_NEEDED (e.g. "librdkafka.so.1")
+	Path string // Where the dynamic loader would find it ("" if not found)
+}
+
+// Dependencies returns the shared libraries an ELF module needs (DT_NEEDED),
+// each resolved the way the dynamic loader would: RPATH, LD_LIBRARY_PATH,
+// RUNPATH, the ld.so.conf directories, then the default directories.
+// Files that are not ELF (e.g. macOS modules) have no reported dependencies.
+func Dependencies(path string) ([]Library, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		if _, ok := err.(*elf.FormatError); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	needed, err := f.DynString(elf.DT_NEEDED)
+	if err != nil {
+		return nil, err
+	}
+
+	origin := filepath.Dir(path)
+	rpath, _ := f.DynString(elf.DT_RPATH)
+	runpath, _ := f.DynString(elf.DT_RUNPATH)
+
+	// RPATH is ignored when RUNPATH is present
+	var dirs []string
+	if len(runpath) == 0 {
+		dirs = append(dirs, expandPaths(rpath, origin)...)
+	}
+	dirs = append(dirs, splitPaths(os.Getenv("LD_LIBRARY_PATH"))...)
+	dirs = append(dirs, expandPaths(runpath, origin)...)
+	dirs = append(dirs, loaderDirs()...)
+
+	libs := make([]Library, 0, len(needed))
+	for _, name := range needed {
+		libs = append(libs, Library{Name: name, Path: resolve(name, dirs, f.Class, f.Machine)})
+	}
+	return libs, nil
+}
+
+// IsELF reports whether a file is an ELF object, whose dependencies
+// Dependencies can report.
+func IsELF(path string) bool {
+	f, err := elf.Open(path)
+	if err != nil {
+		return false
+	}
+	f.Close()
+	return true
+}
+
+// Missing returns the sonames of the libraries a module needs that the
+// dynamic loader would not find.
+func Missing(path string) ([]string, error) {
+	libs, err := Dependencies(path)
+	if err != nil {
+		return nil, err
+	}
+	var missing []string
+	for _, lib := range libs {
+		if lib.Path == "" {
+			missing = append(missing, lib.Name)
+		}
+	}
+	return missing, nil
+}
+
+// resolve finds a library in dirs, skipping files built for another
+// architecture (e.g. 32-bit libraries in a multilib directory).
+func resolve(name string, dirs []string, class elf.Class, machine elf.Machine) string {
+	if strings.Contains(name, "/") {
+		if compatible(name, class, machine) {
+			return name
+		}
+		return ""
+	}
+	for _, dir := range dirs {
+		candidate := filepath.Join(dir, name)
+		if compatible(candidate, class, machine) {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// compatible reports whether path is an ELF file loadable alongside a module
+// of the given class and machine.
+func compatible(path string, class elf.Class, machine elf.Machine) bool {
+	f, err := elf.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	return f.Class == class && f.Machine == machine
+}
+
+// expandPaths splits RPATH/RUNPATH entries and expands $ORIGIN.
+func expandPaths(entries []string, origin string) []string {
+	var dirs []string
+	for _, entry := range entries {
+		for _, dir := range splitPaths(entry) {
+			dir = strings.ReplaceAll(dir, "${ORIGIN}", origin)
+			dir = strings.ReplaceAll(dir, "$ORIGIN", origin)
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+func splitPaths(s string) []string {
+	var dirs []string
+	for _, dir := range strings.Split(s, ":") {
+		if dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// defaultDirs are searched by the loader after everything else. Multiarch
+// directories cover Debian-style layouts.
+var defaultDirs = []string{
+	"/lib64", "/usr/lib64",
+	"/lib", "/usr/lib",
+	"/lib/x86_64-linux-gnu", "/usr/lib/x86_64-linux-gnu",
+	"/lib/aarch64-linux-gnu", "/usr/lib/aarch64-linux-gnu",
+}
+
+// loaderDirs returns the directories configured in /etc/ld.so.conf (which
+// ldconfig caches), followed by the default directories.
+func loaderDirs() []string {
+	dirs := readLdSoConf("/etc/ld.so.conf", map[string]bool{})
+	return append(dirs, defaultDirs...)
+}
+
+// readLdSoConf reads an ld.so.conf file, following include directives.
+func readLdSoConf(path string, seen map[string]bool) []string {
+	if seen[path] {
+		return nil
+	}
+	seen[path] = true
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	var dirs []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if idx := strings.Index(line, "#"); idx != -1 {
+			line = line[:idx]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if pattern, ok := strings.CutPrefix(line, "include "); ok {
+			pattern = strings.TrimSpace(pattern)
+			if !filepath.IsAbs(pattern) {
+				pattern = filepath.Join(filepath.Dir(path), pattern)
+			}
+			matches, _ := filepath.Glob(pattern)
+			for _, m := range matches {
+				dirs = append(dirs, readLdSoConf(m, seen)...)
+			}
+			continue
+		}
+		dirs = append(dirs, line)
+	}
+	return dirs
+}
+
+// ImportedSymbols returns the names of the functions and variables an ELF
+// module expects the process to provide (such as PostgreSQL's server API).
+// Files that are not ELF have no reported symbols.
+func ImportedSymbols(path string) ([]string, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		if _, ok := err.(*elf.FormatError); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	symbols, err := f.ImportedSymbols()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(symbols))
+	for i, s := range symbols {
+		names[i] = s.Name
+	}
+	return names, nil
+}
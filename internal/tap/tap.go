@@ -0,0 +1,362 @@
+package tap
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/matroidbe/pgbrew/internal/github"
+	"github.com/matroidbe/pgbrew/internal/manifest"
+	"github.com/matroidbe/pgbrew/internal/offline"
+)
+
+// Tap is a collection of formulas: a git repository cloned by pgx, or a
+// local directory used in place.
+type Tap struct {
+	Name string `json:"name"`
+	URL  string `json:"url,omitempty"` // Git URL ("" for local taps)
+	Path string `json:"path"`          // Directory holding the formulas
+}
+
+// IsLocal reports whether the tap is a local directory rather than a clone.
+func (t Tap) IsLocal() bool {
+	return t.URL == ""
+}
+
+// Formula describes how to install an extension that doesn't live at a
+// memorable source, or whose repository lacks a pgbrew.toml.
+//
+// Example (Formula/pg_search.toml):
+//
+//	description = "Full-text search with BM25"
+//	source = "github.com/paradedb/paradedb"
+//	subpath = "pg_search"
+//	ref = "v0.15.0"
+//	build_system = "pgrx"
+//	tags = ["search", "bm25"]
+//
+//	[options]
+//	cargo_features = ["icu"]
+//
+//	[system_deps]
+//	debian = ["libicu-dev"]
+type Formula struct {
+	Name          string              `toml:"name"` // Defaults to the file name
+	Description   string              `toml:"description"`
+	Source        string              `toml:"source"`  // GitHub repository or absolute local path
+	Subpath       string              `toml:"subpath"` // Extension directory inside the repository
+	Ref           string              `toml:"ref"`     // Default tag, branch or commit
+	BuildSystem   string              `toml:"build_system"`
+	Tags          []string            `toml:"tags"`
+	SharedPreload *bool               `toml:"shared_preload"`
+	Options       Options             `toml:"options"`
+	SystemDeps    map[string][]string `toml:"system_deps"`
+
+	// Tap is the name of the tap the formula comes from
+	Tap string `toml:"-"`
+}
+
+// Options are the default build options of a formula.
+type Options struct {
+	MakeArgs      []string `toml:"make_args"`
+	CargoFeatures []string `toml:"cargo_features"`
+	Env           []string `toml:"env"`
+	CC            string   `toml:"cc"`
+}
+
+// validName matches tap and formula names
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Dir returns the directory where taps are cloned and registered.
+func Dir() (string, error) {
+	data := os.Getenv("XDG_DATA_HOME")
+	if data == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("could not determine home directory: %w", err)
+		}
+		data = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(data, "pgbrew", "taps"), nil
+}
+
+// List returns the configured taps, in the order they were added. Formulas
+// are looked up in this order.
+func List() ([]Tap, error) {
+	path, err := registryPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var taps []Tap
+	if err := json.Unmarshal(data, &taps); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	return taps, nil
+}
+
+// Get returns the tap with the given name.
+func Get(name string) (*Tap, error) {
+	taps, err := List()
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range taps {
+		if t.Name == name {
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("tap %s not found", name)
+}
+
+// Add registers a tap. An existing directory is used in place; anything
+// else is cloned as a git repository (a GitHub path like
+// "github.com/user/pgbrew-tap" honours the configured mirrors).
+func Add(name string, location string) (*Tap, error) {
+	if !validName.MatchString(name) {
+		return nil, fmt.Errorf("invalid tap name %q", name)
+	}
+	taps, err := List()
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range taps {
+		if t.Name == name {
+			return nil, fmt.Errorf("tap %s already exists", name)
+		}
+	}
+
+	t := Tap{Name: name}
+	if info, err := os.Stat(location); err == nil && info.IsDir() {
+		abs, err := filepath.Abs(location)
+		if err != nil {
+			return nil, err
+		}
+		t.Path = abs
+	} else {
+		if offline.Enabled() {
+			return nil, fmt.Errorf("offline mode: cannot clone %s\n  Add a local copy of the tap instead: pgx tap add %s /path/to/tap", location, name)
+		}
+		dir, err := Dir()
+		if err != nil {
+			return nil, err
+		}
+		t.URL = gitURL(location)
+		t.Path = filepath.Join(dir, name)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+		if err := git("", "clone", "--quiet", t.URL, t.Path); err != nil {
+			os.RemoveAll(t.Path)
+			return nil, err
+		}
+	}
+
+	if err := save(append(taps, t)); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Remove unregisters a tap and deletes its clone. Local taps are left alone.
+func Remove(name string) error {
+	taps, err := List()
+	if err != nil {
+		return err
+	}
+	for i, t := range taps {
+		if t.Name != name {
+			continue
+		}
+		if !t.IsLocal() {
+			if err := os.RemoveAll(t.Path); err != nil {
+				return err
+			}
+		}
+		return save(append(taps[:i], taps[i+1:]...))
+	}
+	return fmt.Errorf("tap %s not found", name)
+}
+
+// Update pulls new formulas into a cloned tap. Local taps are always current.
+func Update(t Tap) error {
+	if t.IsLocal() {
+		return nil
+	}
+	if offline.Enabled() {
+		return fmt.Errorf("offline mode: cannot update tap %s", t.Name)
+	}
+	return git(t.Path, "pull", "--quiet", "--ff-only")
+}
+
+// Formulas returns the formulas of a tap, sorted by name. They are the
+// *.toml files in its Formula directory, or at its root if it has none.
+func (t Tap) Formulas() ([]Formula, error) {
+	dir := filepath.Join(t.Path, "Formula")
+	if _, err := os.Stat(dir); err != nil {
+		dir = t.Path
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.toml"))
+	if err != nil {
+		return nil, err
+	}
+
+	var formulas []Formula
+	for _, path := range paths {
+		f, err := loadFormula(path)
+		if err != nil {
+			return nil, err
+		}
+		f.Tap = t.Name
+		formulas = append(formulas, *f)
+	}
+	sort.Slice(formulas, func(i, j int) bool { return formulas[i].Name < formulas[j].Name })
+	return formulas, nil
+}
+
+// Find looks up a formula by name in all taps, first tap first. The name
+// may be qualified with its tap ("mytap/pg_search").
+func Find(name string) (*Formula, error) {
+	tapName, formulaName, qualified := strings.Cut(name, "/")
+	if !qualified {
+		formulaName = name
+	}
+	if !validName.MatchString(formulaName) {
+		return nil, nil
+	}
+
+	taps, err := List()
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range taps {
+		if qualified && t.Name != tapName {
+			continue
+		}
+		formulas, err := t.Formulas()
+		if err != nil {
+			return nil, fmt.Errorf("tap %s: %w", t.Name, err)
+		}
+		for _, f := range formulas {
+			if f.Name == formulaName {
+				return &f, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// SourceAt returns the source to install the formula from, at ref (the
+// formula's default ref if empty). Local sources have no refs.
+func (f *Formula) SourceAt(ref string) string {
+	source := f.Source
+	if f.Subpath != "" {
+		source += "/" + strings.Trim(f.Subpath, "/")
+	}
+	if ref == "" {
+		ref = f.Ref
+	}
+	if ref != "" && !filepath.IsAbs(f.Source) {
+		source += "@" + ref
+	}
+	return source
+}
+
+// Apply returns the manifest m with the formula's settings taking
+// precedence. m may be nil.
+func (f *Formula) Apply(m *manifest.Manifest) *manifest.Manifest {
+	var merged manifest.Manifest
+	if m != nil {
+		merged = *m
+	}
+	if f.BuildSystem != "" {
+		merged.BuildSystem = f.BuildSystem
+	}
+	if f.SharedPreload != nil {
+		merged.SharedPreload = f.SharedPreload
+	}
+	if len(f.SystemDeps) > 0 {
+		merged.SystemDeps = f.SystemDeps
+	}
+	return &merged
+}
+
+// loadFormula reads a formula file.
+func loadFormula(path string) (*Formula, error) {
+	var f Formula
+	md, err := toml.DecodeFile(path, &f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		return nil, fmt.Errorf("invalid %s: unknown key %q", path, undecoded[0].String())
+	}
+	if f.Name == "" {
+		f.Name = strings.TrimSuffix(filepath.Base(path), ".toml")
+	}
+	if f.Source == "" {
+		return nil, fmt.Errorf("invalid %s: source is required", path)
+	}
+	switch f.BuildSystem {
+	case "", "pgrx", "pgxs":
+	default:
+		return nil, fmt.Errorf("invalid %s: unknown build_system %q", path, f.BuildSystem)
+	}
+	return &f, nil
+}
+
+// gitURL turns a GitHub path into a clone URL; other locations are git URLs.
+func gitURL(location string) string {
+	if strings.Contains(location, "://") || strings.HasPrefix(location, "git@") {
+		return location
+	}
+	if repo, _, _, err := github.ParseURL(location); err == nil {
+		return github.RemoteURL(repo)
+	}
+	return location
+}
+
+func registryPath() (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "taps.json"), nil
+}
+
+func save(taps []Tap) error {
+	path, err := registryPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(taps, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// git runs a git command in dir, including its output in the error on failure.
+func git(dir string, args ...string) error {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git %s failed: %s\n%s", args[0], err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}